@@ -0,0 +1,180 @@
+package urlshort
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of an AccessLogger.
+const (
+	JSONFormat   = "json"
+	LogfmtFormat = "logfmt"
+)
+
+// AccessEntry is a single line of the access log.
+type AccessEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Source    string    `json:"source,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+
+	linkPath string // path of the matched link, for sampling
+}
+
+// AccessLogger writes an AccessEntry for every request to Out,
+// in JSON or logfmt Format.
+type AccessLogger struct {
+	Out    io.Writer
+	Format string
+	// Sample maps paths to the fraction of their requests to log,
+	// 0 logging none of them. The path of the matched link, such
+	// as "/gh/*", is tried before the request path. Paths not in
+	// Sample are always logged.
+	Sample map[string]float64
+
+	mu sync.Mutex
+}
+
+type accessKey struct{}
+
+// noteRedirect records the matched link and target of a request
+// for the access log, if the request is being logged.
+func noteRedirect(r *http.Request, link *Link, target string) {
+	if e, ok := r.Context().Value(accessKey{}).(*AccessEntry); ok {
+		e.Source = link.Source
+		e.Target = target
+		e.linkPath = link.Path
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Handler will return an http.Handler logging the requests served
+// by next.
+func (l *AccessLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &AccessEntry{
+			Time:      start.UTC(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Referrer:  r.Referer(),
+			UserAgent: r.UserAgent(),
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+
+		entry.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if l.sampled(entry) {
+			l.write(entry)
+		}
+	})
+}
+
+func (l *AccessLogger) sampled(e *AccessEntry) bool {
+	rate, ok := l.Sample[e.linkPath]
+	if !ok || e.linkPath == "" {
+		if rate, ok = l.Sample[e.Path]; !ok {
+			return true
+		}
+	}
+	return rate > 0 && (rate >= 1 || rand.Float64() < rate)
+}
+
+func (l *AccessLogger) write(e *AccessEntry) {
+	var line []byte
+	if l.Format == LogfmtFormat {
+		line = []byte(e.logfmt())
+	} else {
+		line, _ = json.Marshal(e)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Out.Write(append(line, '\n'))
+}
+
+func (e *AccessEntry) logfmt() string {
+	b := &strings.Builder{}
+	for _, kv := range [][2]string{
+		{"time", e.Time.Format(time.RFC3339Nano)},
+		{"method", e.Method},
+		{"path", e.Path},
+		{"source", e.Source},
+		{"target", e.Target},
+		{"status", strconv.Itoa(e.Status)},
+		{"latency_ms", strconv.FormatFloat(e.LatencyMS, 'f', -1, 64)},
+		{"referrer", e.Referrer},
+		{"user_agent", e.UserAgent},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(b, "%s=%s", kv[0], logfmtValue(kv[1]))
+	}
+	return b.String()
+}
+
+func logfmtValue(v string) string {
+	if strings.ContainsAny(v, " =\"\\") || strconv.Quote(v) != `"`+v+`"` {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// ParseSample parses sampling rules in the format
+// "/path=rate,/other=rate", as used for AccessLogger.Sample.
+func ParseSample(rules string) (map[string]float64, error) {
+	sample := map[string]float64{}
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid sample rule %q, expected path=rate", rule)
+		}
+		rate, err := strconv.ParseFloat(rule[i+1:], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid sample rate in %q, expected a number from 0 to 1", rule)
+		}
+		sample[rule[:i]] = rate
+	}
+	return sample, nil
+}
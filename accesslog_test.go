@@ -0,0 +1,89 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	store := NewMultiStore(Source{"yaml", NewMapStore(map[string]string{"/a": "https://a.example.com"})})
+	out := &bytes.Buffer{}
+	logger := &AccessLogger{Out: out, Format: JSONFormat}
+	handler := logger.Handler(&RedirectHandler{Store: store, Fallback: getDefaultMux()})
+
+	req := httptest.NewRequest("GET", "/a", nil)
+	req.Header.Set("Referer", "https://ref.example.com")
+	req.Header.Set("User-Agent", "test agent")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %q", out.String())
+	}
+	var e AccessEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Method != "GET" || e.Path != "/a" || e.Source != "yaml" || e.Target != "https://a.example.com" ||
+		e.Status != 302 || e.Referrer != "https://ref.example.com" || e.UserAgent != "test agent" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	e = AccessEntry{}
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != 200 || e.Source != "" || e.Target != "" {
+		t.Errorf("Unexpected fallback entry %+v", e)
+	}
+}
+
+func TestAccessLogLogfmt(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := &AccessLogger{Out: out, Format: LogfmtFormat}
+	req := httptest.NewRequest("GET", "/a", nil)
+	req.Header.Set("User-Agent", `say "hi"`)
+	logger.Handler(Handler(NewMapStore(map[string]string{"/a": "https://a.example.com"}), getDefaultMux())).
+		ServeHTTP(httptest.NewRecorder(), req)
+
+	line := out.String()
+	for _, expected := range []string{
+		" method=GET path=/a target=https://a.example.com status=302 latency_ms=",
+		` user_agent="say \"hi\""` + "\n",
+	} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("Log line %q missing %q", line, expected)
+		}
+	}
+}
+
+func TestAccessLogSample(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := &AccessLogger{Out: out, Sample: map[string]float64{"/gh/*": 0, "/healthz": 0, "/b": 1}}
+	store := NewMapStore(map[string]string{"/gh/*": "https://github.com/", "/b": "https://b.example.com"})
+	handler := logger.Handler(Handler(store, getDefaultMux()))
+	for _, path := range []string{"/gh/golang/go", "/healthz", "/b", "/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Errorf("Expected /b and /c logged, got %q", out.String())
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	sample, err := ParseSample(" /healthz=0, /a=0.5 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sample) != 2 || sample["/healthz"] != 0 || sample["/a"] != 0.5 {
+		t.Errorf("Unexpected sample %v", sample)
+	}
+	for _, rules := range []string{"/a", "/a=x", "/a=2", "/a=-1"} {
+		if _, err := ParseSample(rules); err == nil {
+			t.Errorf("Expected an error for %q", rules)
+		}
+	}
+}
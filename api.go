@@ -0,0 +1,366 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// APIPrefix is the path under which APIHandler expects to be
+// mounted. Links can not be created under this prefix.
+const APIPrefix = "/api/links"
+
+type apiHandler struct {
+	store  Store
+	codes  *CodeGenerator
+	config []Source
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// linkRequest is a link as sent to the API, which may give a ttl
+// instead of expires_at.
+type linkRequest struct {
+	Link
+	TTL string `json:"ttl"`
+	// these shadow the fields of Link, as their times may be
+	// local to TimeZone
+	ActiveFrom  string          `json:"active_from"`
+	ActiveUntil string          `json:"active_until"`
+	TimeZone    string          `json:"timezone"`
+	Schedule    []scheduleEntry `json:"schedule"`
+}
+
+// APIHandler will return an http.Handler serving a JSON API to
+// manage the links of the given Store. It must be mounted at
+// APIPrefix, and supports:
+//
+//     GET    /api/links          list all links
+//     POST   /api/links          create a link, 409 if the path exists
+//     GET    /api/links/<path>   get the link for /<path>
+//     PUT    /api/links/<path>   update the link for /<path>, 404 if missing
+//     DELETE /api/links/<path>   delete the link for /<path>, 404 if missing
+//
+// Links are sent and received as JSON objects in the format:
+//
+//     { "path": "/some-path",
+//       "url": "https://www.some-url.com/demo",
+//       "status": 301,
+//       "query": "merge",
+//       "expires_at": "2030-01-01T00:00:00Z",
+//       "editors": ["marketing"] }
+//
+// where status, query, expires_at and editors are optional. A "ttl"
+// such as "72h" may be sent instead of expires_at, counting from the
+// request. Activation windows and scheduled targets are sent as
+// described for YAMLHandler.
+// If codes is not nil, links created without a path are given
+// one minted by the generator.
+//
+// Links of the config sources are listed too, reported with
+// "config_managed": true, but can not be changed. When protected
+// with Protect, links are owned by the principal creating them and
+// only changed by those it allows, see Principal.CanEdit; only
+// owners and admins may change the editors, and only admins the
+// owner. Updates leaving out the owner or editors keep them.
+func APIHandler(store Store, codes *CodeGenerator, config ...Source) http.Handler {
+	return &apiHandler{store: store, codes: codes, config: config}
+}
+
+func (a *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, APIPrefix) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, APIPrefix)
+	if path == "" || path == "/" {
+		switch r.Method {
+		case http.MethodGet:
+			a.list(w, r)
+		case http.MethodPost:
+			a.create(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if !strings.HasPrefix(path, "/") {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.get(w, r, path)
+	case http.MethodPut:
+		a.update(w, r, path)
+	case http.MethodDelete:
+		a.delete(w, r, path)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *apiHandler) list(w http.ResponseWriter, r *http.Request) {
+	links, err := a.store.List()
+	if len(a.config) > 0 && err == nil {
+		sources := append([]Source{{Store: a.store}}, a.config...)
+		links, err = NewMultiStore(sources...).List()
+		for i := range links {
+			links[i].ConfigManaged = links[i].Source != ""
+		}
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, links)
+}
+
+func (a *apiHandler) get(w http.ResponseWriter, r *http.Request, path string) {
+	link, err := lookupExact(a.store, path)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if link == nil {
+		link, err = a.configLink(path)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if link == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no link for path %q", path))
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+// configLink returns the link for exactly path in the config
+// sources, or nil.
+func (a *apiHandler) configLink(path string) (*Link, error) {
+	for _, s := range a.config {
+		link, err := lookupExact(s.Store, path)
+		if err != nil || link != nil {
+			if link != nil {
+				link.Source, link.ConfigManaged = s.Name, true
+			}
+			return link, err
+		}
+	}
+	return nil, nil
+}
+
+// writeMissing answers a request to change the link for path,
+// which is not in the store: 403 if it is config-managed, 404
+// otherwise.
+func (a *apiHandler) writeMissing(w http.ResponseWriter, path string) {
+	link, err := a.configLink(path)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	} else if link != nil {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("link %q is config-managed and read-only", path))
+	} else {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no link for path %q", path))
+	}
+}
+
+func (a *apiHandler) create(w http.ResponseWriter, r *http.Request) {
+	link, err := decodeLinkRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var codes *CodeGenerator
+	if link.Path == "" && a.codes != nil {
+		path, err := a.codes.Generate()
+		if err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		link.Path, codes = path, a.codes
+	}
+	if err := validateLink(link); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	existing, err := a.configLink(link.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing != nil {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("path %q already exists", link.Path))
+		return
+	}
+	applyOwnership(PrincipalFrom(r), &link, nil)
+	err = createGenerated(storeFor(a.store, r), &link, codes)
+	if err == ErrExists {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("path %q already exists", link.Path))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", APIPrefix+link.Path)
+	writeJSON(w, http.StatusCreated, link)
+}
+
+func (a *apiHandler) update(w http.ResponseWriter, r *http.Request, path string) {
+	link, err := decodeLinkRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if link.Path == "" {
+		link.Path = path
+	}
+	if link.Path != path {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("path %q does not match URL path %q", link.Path, path))
+		return
+	}
+	if err := validateLink(link); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := lookupExact(a.store, path)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing == nil {
+		a.writeMissing(w, path)
+		return
+	}
+	p := PrincipalFrom(r)
+	if !p.CanEdit(existing) {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("not allowed to change link %q", path))
+		return
+	}
+	applyOwnership(p, &link, existing)
+	if err := storeFor(a.store, r).Put(link); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+func (a *apiHandler) delete(w http.ResponseWriter, r *http.Request, path string) {
+	existing, err := lookupExact(a.store, path)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing == nil {
+		a.writeMissing(w, path)
+		return
+	}
+	if !PrincipalFrom(r).CanEdit(existing) {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("not allowed to delete link %q", path))
+		return
+	}
+	if err := storeFor(a.store, r).Delete(path); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeLinkRequest reads the link in the body of r, turning a
+// ttl into its expiry time and resolving its schedule.
+func decodeLinkRequest(r *http.Request) (Link, error) {
+	var req linkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Link{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+	expiresAt, err := expiry(req.ExpiresAt, req.TTL, time.Now())
+	if err != nil {
+		return Link{}, err
+	}
+	req.Link.ExpiresAt = expiresAt
+	req.Link.Source, req.Link.ConfigManaged = "", false
+	schedule := scheduleConfig{req.ActiveFrom, req.ActiveUntil, req.TimeZone, req.Schedule}
+	if err := schedule.apply(&req.Link); err != nil {
+		return Link{}, err
+	}
+	return req.Link, nil
+}
+
+// validateLink checks the link has a usable path and an absolute
+// http(s) target URL.
+func validateLink(link Link) error {
+	if !strings.HasPrefix(link.Path, "/") || link.Path == "/" {
+		return errors.New("path must start with '/' and not be empty")
+	}
+	if IsReserved(link.Path) {
+		return fmt.Errorf("path %q is reserved", link.Path)
+	}
+	if i := strings.Index(link.Path, "*"); i >= 0 && (i != len(link.Path)-1 || !link.IsWildcard()) {
+		return errors.New("'*' is only allowed as the last segment of a wildcard path")
+	}
+	for _, segment := range strings.Split(link.Path, "/") {
+		if strings.ContainsAny(segment, "{}") && placeholder.FindString(segment) != segment {
+			return fmt.Errorf("placeholder in %q must be a whole path segment such as {name}", segment)
+		}
+	}
+	if link.IsTemplate() && link.IsWildcard() {
+		return errors.New("a path can not be both a template and a wildcard")
+	}
+	if err := validateTarget(link.Path, link.URL); err != nil {
+		return err
+	}
+	for _, s := range link.Schedule {
+		if err := validateTarget(link.Path, s.URL); err != nil {
+			return fmt.Errorf("scheduled %v", err)
+		}
+	}
+	if link.Status != 0 && !ValidRedirectStatus(link.Status) {
+		return fmt.Errorf("invalid redirect status %d", link.Status)
+	}
+	if link.Query != "" && !link.Query.Valid() {
+		return fmt.Errorf("invalid query policy %q", link.Query)
+	}
+	if link.VCS != "" && (!ValidVCS(link.VCS) || link.IsTemplate()) {
+		return fmt.Errorf("invalid vcs %q, expected one of %s for a link without placeholders", link.VCS, strings.Join(VCSs, ", "))
+	}
+	return nil
+}
+
+// validateTarget checks target is an absolute http(s) URL whose
+// placeholders are all in path.
+func validateTarget(path, target string) error {
+	for _, m := range placeholder.FindAllStringSubmatch(target, -1) {
+		if !strings.Contains(path, m[0]) {
+			return fmt.Errorf("url placeholder %s is not in the path", m[0])
+		}
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https URL", target)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{msg})
+}
@@ -0,0 +1,148 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func doAPIRequest(handler http.Handler, method, target, body string) *http.Response {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Result()
+}
+
+func TestAPICreateAndRedirect(t *testing.T) {
+	store, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := APIHandler(store, nil)
+	redirect := Handler(store, getDefaultMux())
+
+	res := doAPIRequest(api, "POST", "/api/links", `{"path": "/gh", "url": "https://github.com"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+
+	rr := httptest.NewRecorder()
+	redirect.ServeHTTP(rr, httptest.NewRequest("GET", "/gh", nil))
+	if location := rr.Result().Header.Get("Location"); location != "https://github.com" {
+		t.Errorf("Handler returned wrong location: got %v want %v", location, "https://github.com")
+	}
+
+	res = doAPIRequest(api, "POST", "/api/links", `{"path": "/gh", "url": "https://gitlab.com"}`)
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusConflict)
+	}
+
+	res = doAPIRequest(api, "PUT", "/api/links/gh", `{"url": "https://gitlab.com"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusOK)
+	}
+	res = doAPIRequest(api, "GET", "/api/links/gh", "")
+	var link Link
+	json.NewDecoder(res.Body).Decode(&link)
+	if link.URL != "https://gitlab.com" {
+		t.Errorf("API returned wrong link: got %v", link)
+	}
+
+	res = doAPIRequest(api, "DELETE", "/api/links/gh", "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusNoContent)
+	}
+	res = doAPIRequest(api, "DELETE", "/api/links/gh", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAPIValidation(t *testing.T) {
+	api := APIHandler(NewMapStore(nil), nil)
+	for _, body := range []string{
+		`not json`,
+		`{"path": "nope", "url": "https://github.com"}`,
+		`{"path": "/api/links/x", "url": "https://github.com"}`,
+		`{"path": "/x", "url": "ftp://github.com"}`,
+		`{"path": "/x", "url": "/relative"}`,
+		`{"path": "/x*", "url": "https://github.com"}`,
+		`{"path": "/x/PROJ-{id}", "url": "https://github.com/{id}"}`,
+		`{"path": "/x/{id}", "url": "https://github.com/{other}"}`,
+	} {
+		res := doAPIRequest(api, "POST", "/api/links", body)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("API returned wrong status code for %s: got %v want %v", body, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	res := doAPIRequest(api, "PUT", "/api/links/missing", `{"url": "https://github.com"}`)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAPIGeneratesPath(t *testing.T) {
+	store := NewMapStore(nil)
+	api := APIHandler(store, NewCodeGenerator(store))
+
+	res := doAPIRequest(api, "POST", "/api/links", `{"url": "https://github.com"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("API returned wrong status code: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+	var link Link
+	json.NewDecoder(res.Body).Decode(&link)
+	if len(link.Path) != 7 {
+		t.Errorf("API returned wrong generated path: got %q", link.Path)
+	}
+	if stored, _ := store.Lookup(link.Path); stored == nil {
+		t.Errorf("API did not store generated path %q", link.Path)
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	boltStore, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewCachedStore(boltStore, time.Hour)
+	api := APIHandler(store, nil)
+	ui := UIHandler(NewMultiStore(Source{"bolt", store}), "bolt", nil)
+	token := strings.Repeat("a", 64)
+
+	var wg sync.WaitGroup
+	created := make(chan string, 20)
+	for i := 0; i < cap(created); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			target := fmt.Sprintf("https://example.com/%d", i)
+			if i%2 == 0 {
+				res := doAPIRequest(api, "POST", "/api/links", `{"path": "/race", "url": "`+target+`"}`)
+				if res.StatusCode == http.StatusCreated {
+					created <- target
+				}
+				return
+			}
+			form := url.Values{"csrf_token": {token}, "path": {"/race"}, "url": {target}}
+			if rr := doUIRequest(ui, "POST", UIPrefix+"/new", token, form); rr.Code == http.StatusSeeOther {
+				created <- target
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+
+	if len(created) != 1 {
+		t.Fatalf("Expected exactly one create to succeed, got %d", len(created))
+	}
+	if link, _ := store.Lookup("/race"); link == nil || link.URL != <-created {
+		t.Errorf("Expected the link of the successful create, got %+v", link)
+	}
+}
@@ -0,0 +1,251 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var auditBucketName = []byte("urlshort-audit")
+
+// AuditPrefix is the path under which AuditHandler expects to be
+// mounted.
+const AuditPrefix = "/api/audit"
+
+// Actions of audit records.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// Actors of changes not made for an authenticated principal.
+const (
+	// ActorAnonymous made a change through a request without a
+	// principal, as when authentication is disabled.
+	ActorAnonymous = "anonymous"
+	// ActorSystem made a change outside of any request, such as
+	// purging expired links.
+	ActorSystem = "system"
+)
+
+// AuditRecord records a change to a link: Old is nil for links
+// created and New for links deleted.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	// Actor is the subject of the principal making the change,
+	// or ActorAnonymous or ActorSystem.
+	Actor    string `json:"actor"`
+	SourceIP string `json:"source_ip,omitempty"`
+	Old      *Link  `json:"old,omitempty"`
+	New      *Link  `json:"new,omitempty"`
+}
+
+// AuditLog appends an AuditRecord for every change to the
+// 'urlshort-audit' bucket of a bolt DB. Unlike EventLog, records
+// are written as the changes are made and never purged. Set as the
+// Audit of a BoltStore, it records the changes to its links.
+type AuditLog struct {
+	db *bolt.DB
+}
+
+// NewAuditLog returns an AuditLog writing to db, creating the
+// audit bucket if needed.
+func NewAuditLog(db *bolt.DB) (*AuditLog, error) {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(auditBucketName)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &AuditLog{db}, nil
+}
+
+// appendTx writes record within tx, the transaction making the
+// change it records.
+func (l *AuditLog) appendTx(tx *bolt.Tx, record AuditRecord) error {
+	b := tx.Bucket(auditBucketName)
+	if b == nil {
+		return errors.New("Db missing bucket 'urlshort-audit'")
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return b.Put(eventKey(record.Time, seq), value)
+}
+
+// newAuditRecord returns the record of the change from before to
+// after, either of which may be nil, made for r, which may also be
+// nil.
+func newAuditRecord(r *http.Request, before, after *Link) AuditRecord {
+	record := AuditRecord{Time: time.Now().UTC(), Actor: ActorSystem, Old: auditLink(before), New: auditLink(after)}
+	switch {
+	case before == nil:
+		record.Action, record.Path = AuditCreate, after.Path
+	case after == nil:
+		record.Action, record.Path = AuditDelete, before.Path
+	default:
+		record.Action, record.Path = AuditUpdate, after.Path
+	}
+	if r != nil {
+		record.Actor = ActorAnonymous
+		if p := PrincipalFrom(r); p != nil {
+			record.Actor = p.Subject
+		}
+		record.SourceIP = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			record.SourceIP = host
+		}
+	}
+	return record
+}
+
+// auditLink returns a copy of link as it is stored.
+func auditLink(link *Link) *Link {
+	if link == nil {
+		return nil
+	}
+	l := *link
+	l.Source, l.ConfigManaged = "", false
+	return &l
+}
+
+// AuditFilter selects audit records. Empty fields match any
+// record.
+type AuditFilter struct {
+	Path  string
+	Actor string
+	Since time.Time
+	Until time.Time
+}
+
+// errStopRecords stops the iteration of Records early.
+var errStopRecords = errors.New("enough records")
+
+// Records calls fn for each record matching filter, oldest first.
+func (l *AuditLog) Records(filter AuditFilter, fn func(AuditRecord) error) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucketName).Cursor()
+		for k, v := c.Seek(eventKey(filter.Since, 0)); k != nil; k, v = c.Next() {
+			var record AuditRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if !filter.Until.IsZero() && !record.Time.Before(filter.Until) {
+				return nil
+			}
+			if (filter.Path != "" && record.Path != filter.Path) || (filter.Actor != "" && record.Actor != filter.Actor) {
+				continue
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// requestStore is implemented by stores attributing the changes
+// made through them to the request they are made for, such as a
+// BoltStore with an AuditLog.
+type requestStore interface {
+	ForRequest(r *http.Request) Store
+}
+
+// storeFor returns the store to make the changes requested by r
+// through.
+func storeFor(store Store, r *http.Request) Store {
+	if rs, ok := store.(requestStore); ok {
+		return rs.ForRequest(r)
+	}
+	return store
+}
+
+// AuditHandler will return an http.Handler serving the audit
+// records, oldest first, which should be protected with
+// ScopeAdmin. It must be mounted at AuditPrefix and accepts the
+// query parameters:
+//
+//     path    only records of this link
+//     actor   only records of this actor, such as "key:<id>"
+//     since   RFC 3339 time of the first record
+//     until   RFC 3339 time after the last record
+//     limit   return at most this many records
+//     format  json (default) for an array, or jsonl for JSON lines
+//
+// JSON lines are streamed as application/x-ndjson, suitable for
+// exporting the whole log.
+func AuditHandler(log *AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		filter := AuditFilter{Path: q.Get("path"), Actor: q.Get("actor")}
+		for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if s := q.Get(name); s != "" {
+				var err error
+				if *t, err = time.Parse(time.RFC3339, s); err != nil {
+					writeJSONError(w, http.StatusBadRequest, name+" must be an RFC 3339 time")
+					return
+				}
+			}
+		}
+		limit := -1
+		if s := q.Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				writeJSONError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+				return
+			}
+		}
+		format := q.Get("format")
+		if format != "" && format != "json" && format != "jsonl" {
+			writeJSONError(w, http.StatusBadRequest, "format must be one of json or jsonl")
+			return
+		}
+
+		if format == "jsonl" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(w)
+			n := 0
+			// the status is sent with the first line, so an
+			// error can only cut the export short
+			log.Records(filter, func(record AuditRecord) error {
+				if n == limit {
+					return errStopRecords
+				}
+				n++
+				return enc.Encode(record)
+			})
+			return
+		}
+		records := []AuditRecord{}
+		err := log.Records(filter, func(record AuditRecord) error {
+			if len(records) == limit {
+				return errStopRecords
+			}
+			records = append(records, record)
+			return nil
+		})
+		if err != nil && err != errStopRecords {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	})
+}
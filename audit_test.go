@@ -0,0 +1,141 @@
+package urlshort
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestAuditedAPI(t *testing.T) {
+	db := openTestDB(t)
+	boltStore, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := NewAuditLog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boltStore.Audit = audit
+	auth := testPrincipals{"ann": {Subject: "user:ann", Scopes: RoleEditor.Scopes()}}
+	api := Protect(auth, ScopeReadLinks, ScopeWriteLinks, APIHandler(NewCachedStore(boltStore, time.Hour), nil))
+	do := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer ann")
+		req.RemoteAddr = "192.0.2.10:4321"
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("POST", "/api/links", `{"path": "/docs", "url": "https://docs.example.com"}`)
+	do("PUT", "/api/links/docs", `{"url": "https://new-docs.example.com"}`)
+	do("DELETE", "/api/links/docs", "")
+	do("DELETE", "/api/links/docs", "")
+
+	past := time.Now().Add(-time.Hour)
+	boltStore.Put(Link{Path: "/old", URL: "https://old.example.com", ExpiresAt: &past})
+	if n, err := boltStore.PurgeExpired(time.Now()); n != 1 || err != nil {
+		t.Fatalf("Expected the expired link purged, got %d, %v", n, err)
+	}
+
+	records := []AuditRecord{}
+	if err := audit.Records(AuditFilter{}, func(r AuditRecord) error {
+		records = append(records, r)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("Expected 5 records, got %+v", records)
+	}
+	for i, expected := range []string{AuditCreate, AuditUpdate, AuditDelete} {
+		r := records[i]
+		if r.Action != expected || r.Path != "/docs" || r.Actor != "user:ann" || r.SourceIP != "192.0.2.10" {
+			t.Errorf("Unexpected record %d: %+v", i, r)
+		}
+	}
+	if records[0].Old != nil || records[0].New.URL != "https://docs.example.com" ||
+		records[1].Old.URL != "https://docs.example.com" || records[1].New.URL != "https://new-docs.example.com" ||
+		records[1].New.Owner != "user:ann" || records[2].New != nil {
+		t.Errorf("Unexpected old and new values: %+v", records[:3])
+	}
+	if r := records[3]; r.Action != AuditCreate || r.Actor != ActorSystem {
+		t.Errorf("Expected the direct write recorded, got %+v", r)
+	}
+	if r := records[4]; r.Action != AuditDelete || r.Path != "/old" || r.Actor != ActorSystem || r.SourceIP != "" {
+		t.Errorf("Expected the purge recorded, got %+v", r)
+	}
+
+	// the change is undone if it can not be recorded
+	db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(auditBucketName) })
+	if err := boltStore.Put(Link{Path: "/docs", URL: "https://docs.example.com"}); err == nil {
+		t.Errorf("Expected the change to fail without the audit bucket")
+	}
+	if link, _ := boltStore.Lookup("/docs"); link != nil {
+		t.Errorf("Expected the unrecorded change rolled back, got %+v", link)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(auditBucketName)
+		return err
+	})
+}
+
+func TestAuditHandler(t *testing.T) {
+	db := openTestDB(t)
+	boltStore, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := NewAuditLog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boltStore.Audit = audit
+	req := httptest.NewRequest("POST", APIPrefix, nil)
+	store := boltStore.ForRequest(req)
+	store.Put(Link{Path: "/docs", URL: "https://docs.example.com"})
+	store.Put(Link{Path: "/docs", URL: "https://new-docs.example.com"})
+	store.Delete("/docs")
+	boltStore.Put(Link{Path: "/old", URL: "https://old.example.com"})
+
+	handler := AuditHandler(audit)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", AuditPrefix+"?path=/docs&limit=2", nil))
+	var page []AuditRecord
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil || len(page) != 2 || page[1].Action != AuditUpdate {
+		t.Errorf("Expected the first 2 records of /docs, got %v %+v", err, page)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", AuditPrefix+"?format=jsonl&actor=system", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected JSON lines, got %q", ct)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(rr.Body); scanner.Scan(); lines++ {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Path != "/old" {
+			t.Errorf("Unexpected line %q: %v", scanner.Text(), err)
+		}
+	}
+	if lines != 1 {
+		t.Errorf("Expected 1 line, got %d", lines)
+	}
+
+	for _, query := range []string{"since=yesterday", "limit=-1", "format=csv"} {
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", AuditPrefix+"?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, rr.Code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", AuditPrefix, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected the log append-only, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Scope is a permission granted to a Principal.
+type Scope string
+
+// Scopes of the server's endpoints. ScopeAdmin grants all others.
+const (
+	ScopeReadLinks  Scope = "links:read"
+	ScopeWriteLinks Scope = "links:write"
+	ScopeReadStats  Scope = "stats:read"
+	ScopeAdmin      Scope = "admin"
+)
+
+// Valid reports whether s is one of the known scopes.
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeReadLinks, ScopeWriteLinks, ScopeReadStats, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// ErrUnauthorized is returned by an Authenticator for credentials
+// it does not accept.
+var ErrUnauthorized = errors.New("invalid credentials")
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	// Subject identifies the principal, such as "key:<id>".
+	Subject string `json:"subject"`
+	// Name is the display name of the principal.
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+	// Groups the principal belongs to, as set by an identity
+	// provider.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// HasScope reports whether the principal was granted scope,
+// directly or as an admin.
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the client of a request. Authenticate
+// returns nil and no error for requests without credentials it
+// understands, and ErrUnauthorized (or another error) for invalid
+// ones.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Authenticators tries each of its Authenticators in turn, using
+// the first which recognises the request's credentials.
+type Authenticators []Authenticator
+
+// Authenticate implements Authenticator.
+func (a Authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, auth := range a {
+		if p, err := auth.Authenticate(r); p != nil || err != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the principal a request was authenticated
+// as by Protect, or nil.
+func PrincipalFrom(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Protect will return an http.Handler passing requests on to next
+// only if auth authenticates them with the read scope for GET and
+// HEAD requests, or the write scope for any other method. It
+// answers 401 Unauthorized to requests without valid credentials
+// and 403 Forbidden to those missing the scope. The principal is
+// available to next through PrincipalFrom.
+func Protect(auth Authenticator, read, write Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := auth.Authenticate(r)
+		if p == nil || err != nil {
+			// Basic lets browsers prompt for a key, see
+			// KeyStore.Authenticate
+			w.Header().Add("WWW-Authenticate", `Bearer realm="urlshort"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="urlshort"`)
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		scope := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = read
+		}
+		if !p.HasScope(scope) {
+			writeJSONError(w, http.StatusForbidden, "missing scope "+string(scope))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
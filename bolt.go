@@ -0,0 +1,260 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketName = []byte("urlshort")
+
+// BoltStore is a Store backed by the 'urlshort' bucket of
+// a bolt DB. Keys are paths and values are either plain urls or,
+// for links with more settings than a url, JSON encoded links.
+type BoltStore struct {
+	db *bolt.DB
+	// Audit, if not nil, records every change to the links in
+	// the transaction making it. It must write to the same DB.
+	Audit *AuditLog
+}
+
+// NewBoltStore returns a BoltStore reading from the given DB.
+// An error is returned if the DB is missing the 'urlshort' bucket.
+func NewBoltStore(db *bolt.DB) (*BoltStore, error) {
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketName) == nil {
+			return errors.New("Db missing bucket 'urlshort'")
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Check implements Checker, verifying the DB can be read and
+// still has the 'urlshort' bucket.
+func (s *BoltStore) Check() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketName) == nil {
+			return errors.New("Db missing bucket 'urlshort'")
+		}
+		return nil
+	})
+}
+
+// Lookup implements Store.
+func (s *BoltStore) Lookup(path string) (*Link, error) {
+	return s.lookup(path, anyLink)
+}
+
+// LookupActive is like Lookup but passes over links inactive at
+// now, see Link.Active.
+func (s *BoltStore) LookupActive(path string, now time.Time) (*Link, error) {
+	return s.lookup(path, activeAt(now))
+}
+
+func (s *BoltStore) lookup(path string, accept func(*Link) bool) (*Link, error) {
+	var link *Link
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		var err error
+		link, err = lookupPattern(path, accept, func(p string) (*Link, error) {
+			if v := b.Get([]byte(p)); v != nil {
+				return decodeLink([]byte(p), v)
+			}
+			return nil, nil
+		}, func(p string) ([]Link, error) {
+			templates := []Link{}
+			c := b.Cursor()
+			for _, prefix := range templatePrefixes(p) {
+				for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+					if bytes.Contains(k, []byte("{")) {
+						link, err := decodeLink(k, v)
+						if err != nil {
+							return nil, err
+						}
+						templates = append(templates, *link)
+					}
+				}
+			}
+			return templates, nil
+		})
+		return err
+	})
+	return link, err
+}
+
+// Put implements Store. The change is recorded in the Audit log,
+// if any, as made by ActorSystem; see ForRequest.
+func (s *BoltStore) Put(link Link) error {
+	return s.put(nil, link)
+}
+
+// Create adds the link unless its path is taken, see ErrExists,
+// checking and writing in one transaction. The change is recorded
+// as by Put.
+func (s *BoltStore) Create(link Link) error {
+	return s.create(nil, link)
+}
+
+// Delete implements Store. The change is recorded in the Audit log,
+// if any, as made by ActorSystem; see ForRequest.
+func (s *BoltStore) Delete(path string) error {
+	return s.delete(nil, path)
+}
+
+// ForRequest returns a Store attributing the changes made through
+// it to the principal and client address of r in the Audit log.
+func (s *BoltStore) ForRequest(r *http.Request) Store {
+	return &boltRequestStore{s, r}
+}
+
+type boltRequestStore struct {
+	*BoltStore
+	r *http.Request
+}
+
+func (s *boltRequestStore) Put(link Link) error {
+	return s.put(s.r, link)
+}
+
+func (s *boltRequestStore) Create(link Link) error {
+	return s.create(s.r, link)
+}
+
+func (s *boltRequestStore) Delete(path string) error {
+	return s.delete(s.r, path)
+}
+
+// put writes link and its audit record, if there is an Audit log,
+// in one transaction.
+func (s *BoltStore) put(r *http.Request, link Link) error {
+	value, err := encodeLink(link)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		if s.Audit == nil {
+			return b.Put([]byte(link.Path), value)
+		}
+		old, err := getLink(b, link.Path)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(link.Path), value); err != nil {
+			return err
+		}
+		return s.Audit.appendTx(tx, newAuditRecord(r, old, &link))
+	})
+}
+
+// create writes link, if its path is free, and its audit record in
+// one transaction.
+func (s *BoltStore) create(r *http.Request, link Link) error {
+	value, err := encodeLink(link)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		if b.Get([]byte(link.Path)) != nil {
+			return ErrExists
+		}
+		if err := b.Put([]byte(link.Path), value); err != nil {
+			return err
+		}
+		if s.Audit == nil {
+			return nil
+		}
+		return s.Audit.appendTx(tx, newAuditRecord(r, nil, &link))
+	})
+}
+
+// delete removes the link for path and records it, if there is an
+// Audit log, in one transaction. Deleting a missing path is not
+// recorded.
+func (s *BoltStore) delete(r *http.Request, path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		if s.Audit == nil {
+			return b.Delete([]byte(path))
+		}
+		old, err := getLink(b, path)
+		if err != nil || old == nil {
+			return err
+		}
+		if err := b.Delete([]byte(path)); err != nil {
+			return err
+		}
+		return s.Audit.appendTx(tx, newAuditRecord(r, old, nil))
+	})
+}
+
+// getLink returns the link stored for exactly path in b, or nil.
+func getLink(b *bolt.Bucket, path string) (*Link, error) {
+	if v := b.Get([]byte(path)); v != nil {
+		return decodeLink([]byte(path), v)
+	}
+	return nil, nil
+}
+
+// List implements Store.
+func (s *BoltStore) List() ([]Link, error) {
+	links := []Link{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(key, value []byte) error {
+			link, err := decodeLink(key, value)
+			if err != nil {
+				return err
+			}
+			links = append(links, *link)
+			return nil
+		})
+	})
+	return links, err
+}
+
+// NextSequence implements Sequencer using the bucket's sequence.
+func (s *BoltStore) NextSequence() (uint64, error) {
+	var n uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.Bucket(bucketName).NextSequence()
+		return err
+	})
+	return n, err
+}
+
+// encodeLink returns the bucket value for link: its plain url
+// when that is all there is to it, so the DB stays readable by
+// simpler tools, or the JSON encoded link.
+func encodeLink(link Link) ([]byte, error) {
+	link.Source, link.ConfigManaged = "", false
+	if link.Status == 0 && link.Query == "" && link.ExpiresAt == nil &&
+		link.ActiveFrom == nil && link.ActiveUntil == nil && len(link.Schedule) == 0 && link.VCS == "" &&
+		link.Owner == "" && len(link.Editors) == 0 {
+		return []byte(link.URL), nil
+	}
+	return json.Marshal(link)
+}
+
+// decodeLink parses a bucket value written by encodeLink.
+func decodeLink(key, value []byte) (*Link, error) {
+	link := &Link{}
+	if bytes.HasPrefix(value, []byte("{")) {
+		if err := json.Unmarshal(value, link); err != nil {
+			return nil, fmt.Errorf("invalid link record for %s: %v", key, err)
+		}
+	} else {
+		link.URL = string(value)
+	}
+	link.Path = string(key)
+	return link, nil
+}
@@ -0,0 +1,113 @@
+package urlshort
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Open a fresh DB holding an empty 'urlshort' bucket
+func openTestDB(t *testing.T) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(bucketName)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestBoltStore(t *testing.T) {
+	store, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(Link{Path: "/urlshort", URL: "https://github.com/gophercises/urlshort"}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := store.Lookup("/urlshort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link == nil || link.URL != "https://github.com/gophercises/urlshort" {
+		t.Errorf("Store returned wrong link: got %v", link)
+	}
+
+	if err := store.Delete("/urlshort"); err != nil {
+		t.Fatal(err)
+	}
+	links, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 0 {
+		t.Errorf("Store returned deleted links: got %v", links)
+	}
+}
+
+func TestBoltStoreMissingBucket(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "empty.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := NewBoltStore(db); err == nil {
+		t.Errorf("Store did not return error")
+	}
+}
+
+func TestBoltStoreLiveLookup(t *testing.T) {
+	db := openTestDB(t)
+	store, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Handler(store, getDefaultMux())
+
+	// write behind the store's back, as another tool would
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte("/new"), []byte("https://new.example.com"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/new", nil))
+	if location := rr.Result().Header.Get("Location"); location != "https://new.example.com" {
+		t.Errorf("Handler returned wrong location: got %v want %v", location, "https://new.example.com")
+	}
+}
+
+func TestBoltStoreRecords(t *testing.T) {
+	db := openTestDB(t)
+	store, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(Link{Path: "/plain", URL: "https://plain.example.com"})
+	store.Put(Link{Path: "/moved", URL: "https://moved.example.com", Status: 301})
+
+	// plain links stay readable by tools only knowing about urls
+	db.View(func(tx *bolt.Tx) error {
+		if v := string(tx.Bucket(bucketName).Get([]byte("/plain"))); v != "https://plain.example.com" {
+			t.Errorf("Store wrote wrong value: got %q", v)
+		}
+		return nil
+	})
+
+	link, err := store.Lookup("/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link == nil || link.URL != "https://moved.example.com" || link.Status != 301 {
+		t.Errorf("Store returned wrong link: got %v", link)
+	}
+}
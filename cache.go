@@ -0,0 +1,167 @@
+package urlshort
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the memory held by a CachedStore; the
+// cache is emptied when it fills up.
+const maxCacheEntries = 10000
+
+type cacheEntry struct {
+	link    *Link
+	expires time.Time
+}
+
+// CachedStore fronts another Store with an in-memory cache of
+// lookups. Both hits and misses are cached for the given ttl.
+// Writes made through the CachedStore invalidate the cached
+// path immediately, or every cached path for wildcard and template
+// links. Writes made directly to the underlying store become
+// visible once the cached entry expires.
+type CachedStore struct {
+	store Store
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// generation is bumped by every invalidation, so lookups
+	// racing one do not cache what they read before it
+	generation uint64
+}
+
+// NewCachedStore returns a CachedStore caching lookups on store
+// for ttl.
+func NewCachedStore(store Store, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		store:   store,
+		ttl:     ttl,
+		entries: map[string]cacheEntry{},
+	}
+}
+
+// Lookup implements Store.
+func (c *CachedStore) Lookup(path string) (*Link, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.link, nil
+	}
+
+	link, err := c.store.Lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return link, nil
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.entries = map[string]cacheEntry{}
+	}
+	c.entries[path] = cacheEntry{link, now.Add(c.ttl)}
+	return link, nil
+}
+
+// LookupActive is like Lookup but passes over links inactive at
+// now, see Link.Active. Only lookups finding an inactive link go
+// past the cache.
+func (c *CachedStore) LookupActive(path string, now time.Time) (*Link, error) {
+	link, err := c.Lookup(path)
+	if err != nil || link == nil || link.Active(now) {
+		return link, err
+	}
+	return lookupActive(c.store, path, now)
+}
+
+// Put implements Store.
+func (c *CachedStore) Put(link Link) error {
+	defer c.invalidateLink(link.Path)
+	return c.store.Put(link)
+}
+
+// Create adds the link unless its path is taken in the cached
+// store, see ErrExists.
+func (c *CachedStore) Create(link Link) error {
+	defer c.invalidateLink(link.Path)
+	return createLink(c.store, link)
+}
+
+// Delete implements Store.
+func (c *CachedStore) Delete(path string) error {
+	defer c.invalidateLink(path)
+	return c.store.Delete(path)
+}
+
+// List implements Store. Listing is never cached.
+func (c *CachedStore) List() ([]Link, error) {
+	return c.store.List()
+}
+
+// Check implements Checker if the cached store does.
+func (c *CachedStore) Check() error {
+	if checker, ok := c.store.(Checker); ok {
+		return checker.Check()
+	}
+	return nil
+}
+
+// Invalidate drops the cached lookup for path.
+func (c *CachedStore) Invalidate(path string) {
+	c.mu.Lock()
+	delete(c.entries, path)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// ForRequest returns a Store making the changes for r through the
+// cached store, see BoltStore.ForRequest, and invalidating the
+// cache as Put and Delete do.
+func (c *CachedStore) ForRequest(r *http.Request) Store {
+	return &cachedRequestStore{c, storeFor(c.store, r)}
+}
+
+type cachedRequestStore struct {
+	*CachedStore
+	writes Store
+}
+
+func (c *cachedRequestStore) Put(link Link) error {
+	defer c.invalidateLink(link.Path)
+	return c.writes.Put(link)
+}
+
+func (c *cachedRequestStore) Create(link Link) error {
+	defer c.invalidateLink(link.Path)
+	return createLink(c.writes, link)
+}
+
+func (c *cachedRequestStore) Delete(path string) error {
+	defer c.invalidateLink(path)
+	return c.writes.Delete(path)
+}
+
+// invalidateLink drops the cached lookups a write to the link for
+// path may change: those of every path below a wildcard or
+// template link, which are all dropped, or else of path alone.
+func (c *CachedStore) invalidateLink(path string) {
+	if link := (Link{Path: path}); link.IsWildcard() || link.IsTemplate() {
+		c.Purge()
+	} else {
+		c.Invalidate(path)
+	}
+}
+
+// Purge drops all cached lookups.
+func (c *CachedStore) Purge() {
+	c.mu.Lock()
+	c.entries = map[string]cacheEntry{}
+	c.generation++
+	c.mu.Unlock()
+}
@@ -0,0 +1,96 @@
+package urlshort
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachedStore(t *testing.T) {
+	backing := NewMapStore(map[string]string{"/a": "https://a.example.com"})
+	store := NewCachedStore(backing, time.Hour)
+
+	if link, _ := store.Lookup("/a"); link == nil || link.URL != "https://a.example.com" {
+		t.Fatalf("Store returned wrong link: got %v", link)
+	}
+
+	// direct writes are hidden until the entry expires or is invalidated
+	backing.Put(Link{Path: "/a", URL: "https://changed.example.com"})
+	if link, _ := store.Lookup("/a"); link.URL != "https://a.example.com" {
+		t.Errorf("Store did not cache link: got %v", link)
+	}
+	store.Invalidate("/a")
+	if link, _ := store.Lookup("/a"); link.URL != "https://changed.example.com" {
+		t.Errorf("Store did not invalidate link: got %v", link)
+	}
+
+	// misses are cached too, but writes through the cache invalidate them
+	if link, _ := store.Lookup("/b"); link != nil {
+		t.Fatalf("Store returned unknown link: got %v", link)
+	}
+	store.Put(Link{Path: "/b", URL: "https://b.example.com"})
+	if link, _ := store.Lookup("/b"); link == nil || link.URL != "https://b.example.com" {
+		t.Errorf("Store did not invalidate miss: got %v", link)
+	}
+	store.Delete("/b")
+	if link, _ := store.Lookup("/b"); link != nil {
+		t.Errorf("Store returned deleted link: got %v", link)
+	}
+}
+
+// racingStore runs race once, in the middle of its next Lookup,
+// as a write made while the lookup is in flight.
+type racingStore struct {
+	*MapStore
+	race func()
+}
+
+func (s *racingStore) Lookup(path string) (*Link, error) {
+	link, err := s.MapStore.Lookup(path)
+	if race := s.race; race != nil {
+		s.race = nil
+		race()
+	}
+	return link, err
+}
+
+func TestCachedStoreRacingWrite(t *testing.T) {
+	backing := &racingStore{MapStore: NewMapStore(map[string]string{"/a": "https://a.example.com"})}
+	store := NewCachedStore(backing, time.Hour)
+	backing.race = func() { store.Put(Link{Path: "/a", URL: "https://changed.example.com"}) }
+
+	if link, _ := store.Lookup("/a"); link == nil || link.URL != "https://a.example.com" {
+		t.Fatalf("Store returned wrong link: got %v", link)
+	}
+	if link, _ := store.Lookup("/a"); link == nil || link.URL != "https://changed.example.com" {
+		t.Errorf("Store cached the link read before the write: got %v", link)
+	}
+}
+
+func TestCachedStoreWildcards(t *testing.T) {
+	store := NewCachedStore(NewMapStore(map[string]string{"/gh/*": "https://github.com/"}), time.Hour)
+
+	if link, _ := store.Lookup("/gh/x"); link == nil {
+		t.Fatalf("Store did not match the wildcard")
+	}
+	store.Lookup("/pr/1")
+	store.Delete("/gh/*")
+	if link, _ := store.Lookup("/gh/x"); link != nil {
+		t.Errorf("Store returned a path of a deleted wildcard: got %v", link)
+	}
+	store.Put(Link{Path: "/pr/{num}", URL: "https://github.com/pulls/{num}"})
+	if link, _ := store.Lookup("/pr/1"); link == nil {
+		t.Errorf("Store did not invalidate a miss matching a new template")
+	}
+}
+
+func TestCachedStoreExpiry(t *testing.T) {
+	backing := NewMapStore(nil)
+	store := NewCachedStore(backing, time.Millisecond)
+
+	store.Lookup("/a")
+	backing.Put(Link{Path: "/a", URL: "https://a.example.com"})
+	time.Sleep(5 * time.Millisecond)
+	if link, _ := store.Lookup("/a"); link == nil {
+		t.Errorf("Store did not expire cached miss")
+	}
+}
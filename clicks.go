@@ -0,0 +1,206 @@
+package urlshort
+
+import (
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var clicksBucketName = []byte("urlshort-clicks")
+
+// StatsPrefix is the path under which StatsHandler expects to be
+// mounted.
+const StatsPrefix = "/api/stats"
+
+// RedirectRecorder is notified by a RedirectHandler of every
+// request it redirects. RecordRedirect is called before the
+// response is written, so it must not block.
+type RedirectRecorder interface {
+	RecordRedirect(r *http.Request, link *Link, target string)
+}
+
+// ClickCounter counts the redirects of each link in the
+// 'urlshort-clicks' bucket of a bolt DB, next to the 'urlshort'
+// bucket. Clicks are counted in memory and written to the DB in
+// batches, so recording a click never waits on the DB.
+type ClickCounter struct {
+	db *bolt.DB
+
+	mu      sync.Mutex
+	pending map[string]uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewClickCounter returns a ClickCounter writing the clicks to db
+// every interval, creating the clicks bucket if needed. Close must
+// be called to write the last batch.
+func NewClickCounter(db *bolt.DB, interval time.Duration) (*ClickCounter, error) {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(clicksBucketName)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	c := &ClickCounter{
+		db:      db,
+		pending: map[string]uint64{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run(interval)
+	return c, nil
+}
+
+// RecordRedirect implements RedirectRecorder, counting a click
+// for the path of the link.
+func (c *ClickCounter) RecordRedirect(r *http.Request, link *Link, target string) {
+	c.mu.Lock()
+	c.pending[link.Path]++
+	c.mu.Unlock()
+}
+
+func (c *ClickCounter) run(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.Flush()
+		}
+	}
+}
+
+// Flush writes the pending clicks to the DB. If the write fails
+// the clicks are kept for the next batch.
+func (c *ClickCounter) Flush() error {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = map[string]uint64{}
+	c.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(clicksBucketName)
+		for path, n := range batch {
+			if err := b.Put([]byte(path), encodeCount(decodeCount(b.Get([]byte(path)))+n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.mu.Lock()
+		for path, n := range batch {
+			c.pending[path] += n
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Close stops the background writes and flushes the pending
+// clicks.
+func (c *ClickCounter) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.Flush()
+}
+
+// Counts returns the number of clicks of every clicked path,
+// including those not yet written to the DB.
+func (c *ClickCounter) Counts() (map[string]uint64, error) {
+	counts := map[string]uint64{}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(clicksBucketName).ForEach(func(key, value []byte) error {
+			counts[string(key)] = decodeCount(value)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	for path, n := range c.pending {
+		counts[path] += n
+	}
+	c.mu.Unlock()
+	return counts, nil
+}
+
+// Count returns the number of clicks of path.
+func (c *ClickCounter) Count(path string) (uint64, error) {
+	var n uint64
+	err := c.db.View(func(tx *bolt.Tx) error {
+		n = decodeCount(tx.Bucket(clicksBucketName).Get([]byte(path)))
+		return nil
+	})
+	c.mu.Lock()
+	n += c.pending[path]
+	c.mu.Unlock()
+	return n, err
+}
+
+func encodeCount(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func decodeCount(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+type clickStats struct {
+	Path   string `json:"path"`
+	Clicks uint64 `json:"clicks"`
+}
+
+// StatsHandler will return an http.Handler serving the click
+// counts as JSON. It must be mounted at StatsPrefix, and supports:
+//
+//     GET /api/stats          clicks of every clicked path
+//     GET /api/stats/<path>   clicks of /<path>
+func StatsHandler(counter *ClickCounter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, StatsPrefix)
+		if path == "" || path == "/" {
+			counts, err := counter.Counts()
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			stats := []clickStats{}
+			for p, n := range counts {
+				stats = append(stats, clickStats{p, n})
+			}
+			sort.Slice(stats, func(i, j int) bool { return stats[i].Path < stats[j].Path })
+			writeJSON(w, http.StatusOK, stats)
+			return
+		}
+		n, err := counter.Count(path)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, clickStats{path, n})
+	})
+}
@@ -0,0 +1,52 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClickCounter(t *testing.T) {
+	db := openTestDB(t)
+	counter, err := NewClickCounter(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMapStore(map[string]string{"/gh/*": "https://github.com/", "/a": "https://a.example.com"})
+	handler := &RedirectHandler{Store: store, Fallback: getDefaultMux(), Recorders: []RedirectRecorder{counter}}
+
+	for _, path := range []string{"/gh/x", "/gh/y", "/a", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	// pending clicks are counted before they are written
+	if n, _ := counter.Count("/gh/*"); n != 2 {
+		t.Errorf("Counter returned wrong count: got %v want %v", n, 2)
+	}
+	if err := counter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new counter reads the clicks back from the DB
+	counter, err = NewClickCounter(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counter.Close()
+	counts, err := counter.Counts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts["/gh/*"] != 2 || counts["/a"] != 1 {
+		t.Errorf("Counter returned wrong counts: got %v", counts)
+	}
+
+	rr := httptest.NewRecorder()
+	StatsHandler(counter).ServeHTTP(rr, httptest.NewRequest("GET", "/api/stats/a", nil))
+	var stats clickStats
+	json.NewDecoder(rr.Result().Body).Decode(&stats)
+	if rr.Result().StatusCode != http.StatusOK || stats.Clicks != 1 {
+		t.Errorf("Stats returned wrong clicks: got %v", stats)
+	}
+}
@@ -0,0 +1,208 @@
+package urlshort
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// Base62Alphabet is the default alphabet of generated codes.
+const Base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ReservedWords are first path segments used by the server's own
+// routes. Links under them are rejected and codes matching them
+// are never generated.
+var ReservedWords = []string{"api", "metrics", "healthz", "readyz", "ui"}
+
+// IsReserved reports whether path falls under one of the
+// ReservedWords (compared case-insensitively).
+func IsReserved(path string) bool {
+	first := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return isReservedWord(first, nil)
+}
+
+func isReservedWord(word string, extra []string) bool {
+	for _, lists := range [][]string{ReservedWords, extra} {
+		for _, reserved := range lists {
+			if strings.EqualFold(word, reserved) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sequencer is implemented by stores able to hand out a
+// persistent, increasing sequence number.
+type Sequencer interface {
+	NextSequence() (uint64, error)
+}
+
+// CodeGenerator mints short paths for links created without one.
+// Its fields may be changed before the first call to Generate.
+type CodeGenerator struct {
+	// Alphabet holds the characters codes are made of.
+	Alphabet string
+	// Length is the length of random codes and the minimum
+	// length of sequential codes.
+	Length int
+	// Sequential makes codes follow an increasing sequence
+	// rather than being picked at random.
+	Sequential bool
+	// Sequence persists the sequence of sequential codes. When
+	// nil an in-memory counter is used.
+	Sequence Sequencer
+	// Reserved lists words never to generate, on top of
+	// ReservedWords.
+	Reserved []string
+	// Attempts bounds the retries on collisions with existing
+	// paths or reserved words.
+	Attempts int
+	// Config lists further sources, such as config files, whose
+	// paths are never generated either.
+	Config []Source
+
+	store   Store
+	mu      sync.Mutex
+	counter uint64
+}
+
+// NewCodeGenerator returns a CodeGenerator minting random 6
+// character base62 codes that do not collide with paths in store.
+// If store is a Sequencer it is used for sequential codes.
+func NewCodeGenerator(store Store) *CodeGenerator {
+	g := &CodeGenerator{
+		Alphabet: Base62Alphabet,
+		Length:   6,
+		Attempts: 10,
+		store:    store,
+	}
+	if seq, ok := store.(Sequencer); ok {
+		g.Sequence = seq
+	}
+	return g
+}
+
+// Generate returns a new path, made of a single code, that is
+// not yet used in the store or the Config sources and is not
+// reserved.
+func (g *CodeGenerator) Generate() (string, error) {
+	if !validAlphabet(g.Alphabet) {
+		return "", fmt.Errorf("invalid code alphabet %q", g.Alphabet)
+	}
+	if g.Length < 1 {
+		return "", fmt.Errorf("invalid code length %d", g.Length)
+	}
+	for i := 0; i < g.Attempts; i++ {
+		code, err := g.next()
+		if err != nil {
+			return "", err
+		}
+		if isReservedWord(code, g.Reserved) {
+			continue
+		}
+		used, err := g.used("/" + code)
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return "/" + code, nil
+		}
+	}
+	return "", errors.New("could not generate an unused code, try a longer code length")
+}
+
+// createGenerated adds link through store as createLink does. Its
+// path was generated by codes, or codes is nil; should another
+// link take the generated path in the meantime, codes is asked for
+// another.
+func createGenerated(store Store, link *Link, codes *CodeGenerator) error {
+	err := createLink(store, *link)
+	for i := 1; err == ErrExists && codes != nil && i < codes.Attempts; i++ {
+		if link.Path, err = codes.Generate(); err != nil {
+			return err
+		}
+		err = createLink(store, *link)
+	}
+	return err
+}
+
+// used reports whether path is taken in the store or the Config
+// sources.
+func (g *CodeGenerator) used(path string) (bool, error) {
+	stores := []Store{g.store}
+	for _, s := range g.Config {
+		stores = append(stores, s.Store)
+	}
+	for _, store := range stores {
+		if link, err := lookupExact(store, path); link != nil || err != nil {
+			return link != nil, err
+		}
+	}
+	return false, nil
+}
+
+func (g *CodeGenerator) next() (string, error) {
+	if !g.Sequential {
+		return randomCode(g.Alphabet, g.Length)
+	}
+	var n uint64
+	if g.Sequence != nil {
+		var err error
+		if n, err = g.Sequence.NextSequence(); err != nil {
+			return "", err
+		}
+	} else {
+		g.mu.Lock()
+		g.counter++
+		n = g.counter
+		g.mu.Unlock()
+	}
+	return encodeCode(n, g.Alphabet, g.Length), nil
+}
+
+// validAlphabet checks the alphabet has at least two distinct,
+// printable ASCII characters that are safe in a path segment.
+func validAlphabet(alphabet string) bool {
+	if len(alphabet) < 2 {
+		return false
+	}
+	seen := map[rune]bool{}
+	for _, c := range alphabet {
+		if c <= ' ' || c > '~' || strings.ContainsRune("/?#%", c) || seen[c] {
+			return false
+		}
+		seen[c] = true
+	}
+	return true
+}
+
+// encodeCode writes n in the base of the alphabet, left padded with
+// the alphabet's first character to length.
+func encodeCode(n uint64, alphabet string, length int) string {
+	base := uint64(len(alphabet))
+	code := []byte{}
+	for ; n > 0; n /= base {
+		code = append([]byte{alphabet[n%base]}, code...)
+	}
+	for len(code) < length {
+		code = append([]byte{alphabet[0]}, code...)
+	}
+	return string(code)
+}
+
+func randomCode(alphabet string, length int) (string, error) {
+	code := make([]byte, length)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
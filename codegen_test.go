@@ -0,0 +1,125 @@
+package urlshort
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeGeneratorRandom(t *testing.T) {
+	g := NewCodeGenerator(NewMapStore(nil))
+	g.Alphabet = "ab"
+	g.Length = 8
+
+	path, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 9 || strings.Trim(path[1:], "ab") != "" {
+		t.Errorf("Generator returned wrong path: got %q", path)
+	}
+}
+
+func TestCodeGeneratorSequentialSkipsTaken(t *testing.T) {
+	store := NewMapStore(map[string]string{"/001": "https://taken.example.com"})
+	g := NewCodeGenerator(store)
+	g.Alphabet = "0123456789"
+	g.Length = 3
+	g.Sequential = true
+	g.Reserved = []string{"002"}
+
+	for _, expected := range []string{"/003", "/004"} {
+		path, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != expected {
+			t.Errorf("Generator returned wrong path: got %q want %q", path, expected)
+		}
+	}
+}
+
+func TestCodeGeneratorSkipsConfigPaths(t *testing.T) {
+	g := NewCodeGenerator(NewMapStore(nil))
+	g.Alphabet = "0123456789"
+	g.Length = 3
+	g.Sequential = true
+	g.Config = []Source{{"yaml", NewMapStore(map[string]string{"/001": "https://config.example.com"})}}
+
+	if path, err := g.Generate(); err != nil || path != "/002" {
+		t.Errorf("Generator returned a config path: got %q, %v", path, err)
+	}
+}
+
+func TestCodeGeneratorBoltSequence(t *testing.T) {
+	store, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewCodeGenerator(store)
+	g.Sequential = true
+	g.Length = 1
+
+	first, _ := g.Generate()
+	second, _ := g.Generate()
+	if first != "/1" || second != "/2" {
+		t.Errorf("Generator returned wrong paths: got %q, %q", first, second)
+	}
+}
+
+func TestCodeGeneratorExhausted(t *testing.T) {
+	g := NewCodeGenerator(NewMapStore(map[string]string{"/a": "x", "/b": "y"}))
+	g.Alphabet = "ab"
+	g.Length = 1
+	if _, err := g.Generate(); err == nil {
+		t.Errorf("Generator did not return error")
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"/api":           true,
+		"/API/links/foo": true,
+		"/apix":          false,
+		"/gh":            false,
+	} {
+		if IsReserved(path) != expected {
+			t.Errorf("IsReserved(%q) returned %v", path, !expected)
+		}
+	}
+}
+
+// takenOnce is a store whose first Create finds its path taken,
+// as if another link was created in between.
+type takenOnce struct {
+	*MapStore
+	taken bool
+}
+
+func (s *takenOnce) Create(link Link) error {
+	if !s.taken {
+		s.taken = true
+		return ErrExists
+	}
+	return s.MapStore.Create(link)
+}
+
+func TestCreateGeneratedRetries(t *testing.T) {
+	store := &takenOnce{MapStore: NewMapStore(nil)}
+	g := NewCodeGenerator(store)
+	g.Alphabet = "0123456789"
+	g.Length = 3
+	g.Sequential = true
+
+	path, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := Link{Path: path, URL: "https://example.com"}
+	if err := createGenerated(store, &link, g); err != nil || link.Path != "/002" {
+		t.Errorf("Expected the link created under the next code, got %q, %v", link.Path, err)
+	}
+	link = Link{Path: "/002", URL: "https://example.com"}
+	if err := createGenerated(store, &link, nil); err != ErrExists {
+		t.Errorf("Expected a given path not replaced, got %v", err)
+	}
+}
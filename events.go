@@ -0,0 +1,328 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var eventsBucketName = []byte("urlshort-events")
+
+// EventsPrefix is the path under which EventsHandler expects to be
+// mounted.
+const EventsPrefix = "/api/events"
+
+// maxPendingEvents bounds the events buffered between writes;
+// events beyond it are dropped rather than slowing redirects.
+const maxPendingEvents = 10000
+
+// Event records a single redirect.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Path      string    `json:"path"`
+	Target    string    `json:"target"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// ClientIP is the client address with its host part zeroed:
+	// the last byte of IPv4 and the last 80 bits of IPv6
+	// addresses.
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// EventLog appends an Event for every redirect to the
+// 'urlshort-events' bucket of a bolt DB. Like ClickCounter,
+// events are buffered and written in batches. Events older than
+// the retention window are purged after every batch.
+type EventLog struct {
+	db        *bolt.DB
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending []Event
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewEventLog returns an EventLog writing events to db every
+// interval and keeping them for retention, or forever if 0. Close
+// must be called to write the last batch.
+func NewEventLog(db *bolt.DB, interval, retention time.Duration) (*EventLog, error) {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucketName)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	l := &EventLog{
+		db:        db,
+		retention: retention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go l.run(interval)
+	return l, nil
+}
+
+// RecordRedirect implements RedirectRecorder.
+func (l *EventLog) RecordRedirect(r *http.Request, link *Link, target string) {
+	e := Event{
+		Time:      time.Now().UTC(),
+		Path:      link.Path,
+		Target:    target,
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		ClientIP:  anonymizeIP(r.RemoteAddr),
+	}
+	l.mu.Lock()
+	if len(l.pending) < maxPendingEvents {
+		l.pending = append(l.pending, e)
+	}
+	l.mu.Unlock()
+}
+
+// anonymizeIP strips the port and host part of a remote address.
+func anonymizeIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func (l *EventLog) run(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Flush()
+			l.Purge()
+		}
+	}
+}
+
+// Flush writes the pending events to the DB. If the write fails
+// the events are kept for the next batch, as far as the buffer
+// allows.
+func (l *EventLog) Flush() error {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucketName)
+		for _, e := range batch {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(eventKey(e.Time, seq), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		l.mu.Lock()
+		l.pending = append(batch, l.pending...)
+		if len(l.pending) > maxPendingEvents {
+			l.pending = l.pending[:maxPendingEvents]
+		}
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// eventKey orders events by time, the sequence number keeping
+// the keys of simultaneous events apart. Times before 1970 all
+// map to the first key.
+func eventKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	if t.Unix() > 0 {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// Purge deletes the events older than the retention window.
+func (l *EventLog) Purge() error {
+	if l.retention <= 0 {
+		return nil
+	}
+	cutoff := eventKey(time.Now().Add(-l.retention), 0)
+	return l.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucketName).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops the background writes and flushes the pending
+// events.
+func (l *EventLog) Close() error {
+	close(l.stop)
+	<-l.done
+	return l.Flush()
+}
+
+// Events calls fn for each written event since the given time,
+// oldest first.
+func (l *EventLog) Events(since time.Time, fn func(Event) error) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucketName).Cursor()
+		for k, v := c.Seek(eventKey(since, 0)); k != nil; k, v = c.Next() {
+			var e Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Granularity is the width of the time buckets clicks are
+// aggregated in: "hour", "day" or "week".
+type Granularity string
+
+// Granularities of aggregated clicks. Weeks start on Monday, and
+// all buckets are in UTC.
+const (
+	Hourly Granularity = "hour"
+	Daily  Granularity = "day"
+	Weekly Granularity = "week"
+)
+
+// Truncate returns the start of the bucket t falls in.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch g {
+	case Hourly:
+		return t.Truncate(time.Hour)
+	case Weekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// Valid reports whether g is a known granularity.
+func (g Granularity) Valid() bool {
+	return g == Hourly || g == Daily || g == Weekly
+}
+
+// ClickBucket is the number of clicks in the time bucket starting
+// at Start.
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// LinkClicks are the aggregated clicks of a link.
+type LinkClicks struct {
+	Path    string        `json:"path"`
+	Buckets []ClickBucket `json:"buckets"`
+}
+
+// Aggregate counts the clicks of each link since the given time
+// in buckets of the given granularity. If path is not empty only
+// that link is counted. Buckets without clicks are omitted.
+func (l *EventLog) Aggregate(path string, by Granularity, since time.Time) ([]LinkClicks, error) {
+	counts := map[string]map[time.Time]int{}
+	if err := l.Events(since, func(e Event) error {
+		if path != "" && e.Path != path {
+			return nil
+		}
+		if counts[e.Path] == nil {
+			counts[e.Path] = map[time.Time]int{}
+		}
+		counts[e.Path][by.Truncate(e.Time)]++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	result := []LinkClicks{}
+	for p, buckets := range counts {
+		lc := LinkClicks{Path: p}
+		for start, n := range buckets {
+			lc.Buckets = append(lc.Buckets, ClickBucket{start, n})
+		}
+		sort.Slice(lc.Buckets, func(i, j int) bool { return lc.Buckets[i].Start.Before(lc.Buckets[j].Start) })
+		result = append(result, lc)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// EventsHandler will return an http.Handler serving aggregated
+// clicks as JSON. It must be mounted at EventsPrefix and accepts
+// the query parameters:
+//
+//     by     bucket width: hour, day (default) or week
+//     path   only count this link
+//     since  RFC 3339 time of the first event, defaults to 30 days ago
+func EventsHandler(log *EventLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		by := Granularity(q.Get("by"))
+		if by == "" {
+			by = Daily
+		}
+		if !by.Valid() {
+			writeJSONError(w, http.StatusBadRequest, "by must be one of hour, day or week")
+			return
+		}
+		since := time.Now().AddDate(0, 0, -30)
+		if s := q.Get("since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, s); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+				return
+			}
+		}
+		clicks, err := log.Aggregate(q.Get("path"), by, since)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, clicks)
+	})
+}
@@ -0,0 +1,83 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"192.168.1.42:5555":       "192.168.1.0",
+		"[2001:db8:1:2::42]:5555": "2001:db8:1::",
+		"garbage":                 "",
+	} {
+		if actual := anonymizeIP(addr); actual != expected {
+			t.Errorf("anonymizeIP(%q) returned %q want %q", addr, actual, expected)
+		}
+	}
+}
+
+func TestGranularityTruncate(t *testing.T) {
+	// a Wednesday
+	ts := time.Date(2020, 7, 15, 13, 45, 0, 0, time.UTC)
+	for g, expected := range map[Granularity]time.Time{
+		Hourly: time.Date(2020, 7, 15, 13, 0, 0, 0, time.UTC),
+		Daily:  time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC),
+		Weekly: time.Date(2020, 7, 13, 0, 0, 0, 0, time.UTC),
+	} {
+		if actual := g.Truncate(ts); !actual.Equal(expected) {
+			t.Errorf("%s.Truncate returned %v want %v", g, actual, expected)
+		}
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	db := openTestDB(t)
+	events, err := NewEventLog(db, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+	handler := &RedirectHandler{
+		Store:     NewMapStore(map[string]string{"/a": "https://a.example.com", "/b": "https://b.example.com"}),
+		Recorders: []RedirectRecorder{events},
+	}
+	for _, path := range []string{"/a", "/a", "/b"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Referer", "https://referrer.example.com")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := events.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var first Event
+	events.Events(time.Time{}, func(e Event) error {
+		if first.Path == "" {
+			first = e
+		}
+		return nil
+	})
+	if first.Path != "/a" || first.Target != "https://a.example.com" || first.Referrer != "https://referrer.example.com" || first.ClientIP != "192.0.2.0" {
+		t.Errorf("Log recorded wrong event: got %+v", first)
+	}
+
+	rr := httptest.NewRecorder()
+	EventsHandler(events).ServeHTTP(rr, httptest.NewRequest("GET", "/api/events?by=hour", nil))
+	clicks := []LinkClicks{}
+	json.NewDecoder(rr.Result().Body).Decode(&clicks)
+	if len(clicks) != 2 || clicks[0].Path != "/a" || len(clicks[0].Buckets) != 1 || clicks[0].Buckets[0].Clicks != 2 {
+		t.Errorf("Handler returned wrong clicks: got %+v", clicks)
+	}
+
+	// events older than the retention window are purged
+	events.retention = time.Nanosecond
+	if err := events.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if clicks, _ := events.Aggregate("", Daily, time.Time{}); len(clicks) != 0 {
+		t.Errorf("Log kept expired events: got %+v", clicks)
+	}
+}
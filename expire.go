@@ -0,0 +1,82 @@
+package urlshort
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Expired reports whether the link has expired at now.
+func (l *Link) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// expiry returns the expiry time of a link given either an
+// absolute expiresAt or a ttl, such as "72h", counted from now.
+func expiry(expiresAt *time.Time, ttl string, now time.Time) (*time.Time, error) {
+	if ttl == "" {
+		return expiresAt, nil
+	}
+	if expiresAt != nil {
+		return nil, fmt.Errorf("only one of expires_at and ttl may be set")
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid ttl %q, expected a positive duration such as 72h", ttl)
+	}
+	t := now.Add(d).UTC()
+	return &t, nil
+}
+
+// PurgeExpired deletes the links which expired before the given
+// time, returning how many were deleted. The deletions are
+// recorded in the Audit log, if any, as made by ActorSystem.
+func (s *BoltStore) PurgeExpired(before time.Time) (int, error) {
+	n := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		expired := []*Link{}
+		if err := b.ForEach(func(key, value []byte) error {
+			link, err := decodeLink(key, value)
+			if err != nil {
+				return err
+			}
+			if link.Expired(before) {
+				expired = append(expired, link)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, link := range expired {
+			if err := b.Delete([]byte(link.Path)); err != nil {
+				return err
+			}
+			if s.Audit != nil {
+				if err := s.Audit.appendTx(tx, newAuditRecord(nil, link, nil)); err != nil {
+					return err
+				}
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+// Sweep purges every interval the links which expired more than
+// grace ago, until stop is closed. Until then expired links are
+// kept, answering 410 Gone rather than 404 Not Found.
+func (s *BoltStore) Sweep(interval, grace time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.PurgeExpired(time.Now().Add(-grace))
+		}
+	}
+}
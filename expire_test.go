@@ -0,0 +1,112 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExpiredLinkIsGone(t *testing.T) {
+	yml := []byte(`
+- path: /old
+  url: https://old.example.com
+  expires_at: 2001-01-01T00:00:00Z
+- path: /new
+  url: https://new.example.com
+  ttl: 1h
+`)
+	store, err := NewYAMLStore(yml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &RedirectHandler{Store: store, Fallback: getDefaultMux()}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/old", nil))
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected 410 for an expired link, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/new", nil))
+	if rr.Code != http.StatusFound {
+		t.Errorf("Expected 302 before the ttl passed, got %d", rr.Code)
+	}
+
+	handler.Gone = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("custom"))
+	})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/old", nil))
+	if rr.Code != http.StatusGone || rr.Body.String() != "custom" {
+		t.Errorf("Expected the Gone handler, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestExpiryConfigErrors(t *testing.T) {
+	for _, yml := range []string{
+		"- {path: /a, url: 'https://a.example.com', ttl: soon}",
+		"- {path: /a, url: 'https://a.example.com', ttl: -1h}",
+		"- {path: /a, url: 'https://a.example.com', ttl: 1h, expires_at: 2030-01-01T00:00:00Z}",
+		"- {path: /a, url: 'https://a.example.com', expires_at: soon}",
+	} {
+		if _, err := NewYAMLStore([]byte(yml)); err == nil {
+			t.Errorf("Expected an error for %s", yml)
+		}
+	}
+}
+
+func TestAPITTL(t *testing.T) {
+	handler := APIHandler(NewMapStore(nil), nil)
+	res := doAPIRequest(handler, "POST", APIPrefix, `{"path": "/a", "url": "https://a.example.com", "ttl": "1h"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", res.StatusCode)
+	}
+	var link Link
+	if err := json.NewDecoder(res.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	if link.ExpiresAt == nil || link.ExpiresAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("Expected the link to expire in an hour, got %v", link.ExpiresAt)
+	}
+}
+
+func TestBoltPurgeExpired(t *testing.T) {
+	store, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	longAgo, recently, later := now.Add(-48*time.Hour), now.Add(-time.Minute), now.Add(time.Hour)
+	for _, link := range []Link{
+		{Path: "/long-ago", URL: "https://a.example.com", ExpiresAt: &longAgo},
+		{Path: "/recently", URL: "https://b.example.com", ExpiresAt: &recently},
+		{Path: "/later", URL: "https://c.example.com", ExpiresAt: &later},
+		{Path: "/never", URL: "https://d.example.com"},
+	} {
+		if err := store.Put(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link, err := store.Lookup("/later")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.ExpiresAt == nil || !link.ExpiresAt.Equal(later) {
+		t.Errorf("Expected expires_at to round-trip, got %v", link.ExpiresAt)
+	}
+
+	n, err := store.PurgeExpired(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(links) != 3 || links[0].Path != "/later" {
+		t.Errorf("Expected only /long-ago purged, got %d purged and %v left", n, links)
+	}
+}
@@ -0,0 +1,165 @@
+package urlshort
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned when writing to a Store that can only
+// be changed by editing its source.
+var ErrReadOnly = errors.New("store is read-only")
+
+// FileStore is a read-only Store loaded from a YAML or JSON
+// config file, which can be reloaded while serving requests. A
+// reload parses the whole file before swapping it in, so lookups
+// always see either the old or the new table; if the file is
+// invalid the old table is kept. The parser is given the file's
+// modification time to count ttls from, so reloading a file that
+// was merely touched does not push its expiries back.
+type FileStore struct {
+	path  string
+	parse func([]byte, time.Time) (*MapStore, error)
+
+	// OnReload, if set, is called after each reload attempt with
+	// its error, or nil on success.
+	OnReload func(err error)
+
+	mu       sync.RWMutex
+	current  *MapStore
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	lastErr  error
+}
+
+// NewFileStore reads the file at path and parses it with parse,
+// which is usually NewYAMLStoreAt or NewJSONStoreAt.
+func NewFileStore(path string, parse func([]byte, time.Time) (*MapStore, error)) (*FileStore, error) {
+	f := &FileStore{path: path, parse: parse}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path of the config file.
+func (f *FileStore) Path() string {
+	return f.path
+}
+
+// Reload re-reads and parses the file, replacing the served
+// table only if the file is valid.
+func (f *FileStore) Reload() error {
+	err := f.load()
+	f.mu.Lock()
+	f.lastErr = err
+	f.mu.Unlock()
+	if f.OnReload != nil {
+		f.OnReload(err)
+	}
+	return err
+}
+
+func (f *FileStore) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	// remember the attempt even if it fails, so a broken file is
+	// not reloaded again until it is edited
+	f.mu.Lock()
+	f.modTime, f.size = info.ModTime(), info.Size()
+	f.mu.Unlock()
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	store, err := f.parse(content, info.ModTime())
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.current = store
+	f.loadedAt = time.Now()
+	f.mu.Unlock()
+	return nil
+}
+
+// LoadedAt returns when the served table was loaded.
+func (f *FileStore) LoadedAt() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.loadedAt
+}
+
+// LastError returns the error of the last reload, or nil if it
+// succeeded.
+func (f *FileStore) LastError() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.lastErr
+}
+
+// changed reports whether the file was modified since the last
+// load attempt.
+func (f *FileStore) changed() bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
+
+// Watch checks the file every interval and reloads it when it
+// changes, until stop is closed.
+func (f *FileStore) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if f.changed() {
+				f.Reload()
+			}
+		}
+	}
+}
+
+func (f *FileStore) table() *MapStore {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.current
+}
+
+// Lookup implements Store.
+func (f *FileStore) Lookup(path string) (*Link, error) {
+	return f.table().Lookup(path)
+}
+
+// LookupActive is like Lookup but passes over links inactive at
+// now, see Link.Active.
+func (f *FileStore) LookupActive(path string, now time.Time) (*Link, error) {
+	return f.table().LookupActive(path, now)
+}
+
+// Put implements Store, always returning ErrReadOnly.
+func (f *FileStore) Put(link Link) error {
+	return ErrReadOnly
+}
+
+// Delete implements Store, always returning ErrReadOnly.
+func (f *FileStore) Delete(path string) error {
+	return ErrReadOnly
+}
+
+// List implements Store.
+func (f *FileStore) List() ([]Link, error) {
+	return f.table().List()
+}
@@ -0,0 +1,92 @@
+package urlshort
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paths.yaml")
+	if err := ioutil.WriteFile(path, []byte("- path: /a\n  url: https://a.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path, NewYAMLStoreAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := []error{}
+	store.OnReload = func(err error) { reloads = append(reloads, err) }
+
+	if err := ioutil.WriteFile(path, []byte("- path: /b\n  url: https://b.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !store.changed() {
+		t.Errorf("Store did not notice file change")
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := store.Lookup("/b"); link == nil {
+		t.Errorf("Store did not reload new link")
+	}
+	if link, _ := store.Lookup("/a"); link != nil {
+		t.Errorf("Store kept removed link: got %v", link)
+	}
+
+	// an invalid file keeps the previous table
+	if err := ioutil.WriteFile(path, []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Errorf("Store did not return error")
+	}
+	if link, _ := store.Lookup("/b"); link == nil {
+		t.Errorf("Store dropped links on invalid reload")
+	}
+	if len(reloads) != 2 || reloads[0] != nil || reloads[1] == nil {
+		t.Errorf("Store reported wrong reloads: got %v", reloads)
+	}
+}
+
+func TestFileStoreTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paths.yaml")
+	if err := ioutil.WriteFile(path, []byte("- {path: /a, url: 'https://a.example.com', ttl: 1h}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path, NewYAMLStoreAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := modTime.Add(time.Hour)
+	if link, _ := store.Lookup("/a"); link == nil || link.ExpiresAt == nil || !link.ExpiresAt.Equal(expected) {
+		t.Fatalf("Expected the ttl counted from the modification time, got %+v", link)
+	}
+	// reloading the unchanged file does not push the expiry back
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := store.Lookup("/a"); link == nil || !link.ExpiresAt.Equal(expected) {
+		t.Errorf("Expected the expiry kept on reload, got %+v", link)
+	}
+}
+
+func TestFileStoreReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paths.json")
+	if err := ioutil.WriteFile(path, []byte(`[]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path, NewJSONStoreAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(Link{Path: "/a", URL: "https://a.example.com"}); err != ErrReadOnly {
+		t.Errorf("Store returned wrong error: got %v want %v", err, ErrReadOnly)
+	}
+}
@@ -0,0 +1,77 @@
+package urlshort
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VCSs are the version control systems Go import links may use,
+// "mod" serving the module from a proxy.
+var VCSs = []string{"git", "hg", "svn", "bzr", "fossil", "mod"}
+
+// ValidVCS reports whether vcs is one of VCSs.
+func ValidVCS(vcs string) bool {
+	for _, v := range VCSs {
+		if vcs == v {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGoImport reports whether the link is a Go vanity import path,
+// with a VCS set and its URL pointing at the repository. Requests
+// with "?go-get=1" are answered with the go-import and go-source
+// meta tags instead of a redirect. Such links are usually wildcard
+// links, such as "/mylib/*", so that sub-packages resolve too;
+// browsers are redirected to the repository for all of them.
+func (l *Link) IsGoImport() bool {
+	return l.VCS != ""
+}
+
+var goImportPage = template.Must(template.New("go-import").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="{{.Prefix}} {{.VCS}} {{.Repo}}">
+<meta name="go-source" content="{{.Prefix}} {{.Source}}">
+<meta http-equiv="refresh" content="0; url={{.Repo}}">
+</head>
+<body>
+<a href="{{.Repo}}">{{.Repo}}</a>
+</body>
+</html>
+`))
+
+// serveGoImport writes the go-import page of link for the host of
+// request r.
+func serveGoImport(w http.ResponseWriter, r *http.Request, link *Link) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	goImportPage.Execute(w, struct{ Prefix, VCS, Repo, Source string }{
+		Prefix: r.Host + strings.TrimSuffix(link.Path, "/*"),
+		VCS:    link.VCS,
+		Repo:   link.URL,
+		Source: goSource(link.URL),
+	})
+}
+
+// goSource returns the home, directory and file templates of the
+// go-source meta tag for well known code hosts, and "_" for the
+// templates of any other repository.
+func goSource(repo string) string {
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+	u, err := url.Parse(repo)
+	if err != nil {
+		return repo + " _ _"
+	}
+	switch u.Host {
+	case "github.com":
+		return repo + " " + repo + "/tree/HEAD{/dir} " + repo + "/blob/HEAD{/dir}/{file}#L{line}"
+	case "gitlab.com":
+		return repo + " " + repo + "/-/tree/HEAD{/dir} " + repo + "/-/blob/HEAD{/dir}/{file}#L{line}"
+	case "bitbucket.org":
+		return repo + " " + repo + "/src/HEAD{/dir} " + repo + "/src/HEAD{/dir}/{file}#lines-{line}"
+	}
+	return repo + " _ _"
+}
@@ -0,0 +1,52 @@
+package urlshort
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoImport(t *testing.T) {
+	store, err := NewYAMLStore([]byte(`
+- path: /mylib/*
+  url: https://github.com/example/mylib
+  vcs: git
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &RedirectHandler{Store: store, Fallback: getDefaultMux()}
+
+	for _, path := range []string{"/mylib", "/mylib/sub/pkg"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://go.example.com"+path+"?go-get=1", nil)
+		handler.ServeHTTP(rr, req)
+		body, _ := io.ReadAll(rr.Result().Body)
+		for _, expected := range []string{
+			`<meta name="go-import" content="go.example.com/mylib git https://github.com/example/mylib">`,
+			`<meta name="go-source" content="go.example.com/mylib https://github.com/example/mylib ` +
+				`https://github.com/example/mylib/tree/HEAD{/dir} https://github.com/example/mylib/blob/HEAD{/dir}/{file}#L{line}">`,
+		} {
+			if rr.Code != http.StatusOK || !strings.Contains(string(body), expected) {
+				t.Errorf("%s: expected %q in %d response:\n%s", path, expected, rr.Code, body)
+			}
+		}
+
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if loc := rr.Header().Get("Location"); rr.Code != http.StatusFound || loc != "https://github.com/example/mylib" {
+			t.Errorf("%s: expected a redirect to the repository, got %d %q", path, rr.Code, loc)
+		}
+	}
+}
+
+func TestGoSource(t *testing.T) {
+	if s := goSource("https://git.example.com/mylib.git"); s != "https://git.example.com/mylib _ _" {
+		t.Errorf("Unexpected go-source for an unknown host: %q", s)
+	}
+	if _, err := NewYAMLStore([]byte("- {path: /a, url: 'https://a.example.com', vcs: cvs}")); err == nil {
+		t.Error("Expected an error for an unknown vcs")
+	}
+}
@@ -1,41 +1,110 @@
 package urlshort
 
 import (
-	"encoding/json"
-	"errors"
 	"net/http"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
-	"gopkg.in/yaml.v2"
 )
 
-type mapPathHandler struct {
-	pathMap  map[string]string
-	fallback http.Handler
+// RedirectHandler is an http.Handler redirecting requests to the
+// URL of the link their path matches in Store. Requests matching
+// no link are passed to Fallback.
+type RedirectHandler struct {
+	Store    Store
+	Fallback http.Handler
+	// DefaultStatus is the redirect status code of links without
+	// one, http.StatusFound if 0.
+	DefaultStatus int
+	// DefaultQuery is the query policy of links without one,
+	// QueryDrop if empty.
+	DefaultQuery QueryPolicy
+	// Recorders are notified of every redirect.
+	Recorders []RedirectRecorder
+	// Metrics, if set, records lookups, redirects and fallbacks.
+	Metrics *Metrics
+	// Gone serves requests for expired links, which are answered
+	// with a plain 410 Gone if it is nil.
+	Gone http.Handler
 }
 
-type pathConfig struct {
-	Path string `yaml:"path" json:"path"`
-	URL  string `yaml:"url" json:"url"`
+// ValidRedirectStatus reports whether code is a redirect status
+// code links may use: 301, 302, 303, 307 or 308.
+func ValidRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
 }
 
-// Generate a path map from a list of maps as per marshalled config, and a fallback
-func pathConfigToHandler(shortPaths []pathConfig, fallback http.Handler) mapPathHandler {
-	// Note: duplicate URLs are squashed
-	pathsToUrls := map[string]string{}
-	for _, v := range shortPaths {
-		pathsToUrls[v.Path] = v.URL
-	}
-	return mapPathHandler{pathsToUrls, fallback}
+func (h *RedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.redirectToPath(w, r)
 }
 
-func (m *mapPathHandler) redirectToPath(w http.ResponseWriter, r *http.Request) {
-	url := m.pathMap[r.URL.Path]
-	if url != "" {
-		http.Redirect(w, r, url, http.StatusFound)
+func (h *RedirectHandler) redirectToPath(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	link, err := lookupActive(h.Store, r.URL.Path, now)
+	h.Metrics.ObserveLookup(time.Since(now))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if link != nil && link.URL != "" && link.Expired(now) {
+		noteRedirect(r, link, "")
+		if h.Gone != nil {
+			h.Gone.ServeHTTP(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		}
+	} else if link != nil && link.URL != "" && link.IsGoImport() && r.URL.Query().Get("go-get") == "1" {
+		noteRedirect(r, link, "")
+		serveGoImport(w, r, link)
+	} else if link != nil && link.URL != "" {
+		link = link.At(now)
+		target := h.target(link, r)
+		for _, recorder := range h.Recorders {
+			recorder.RecordRedirect(r, link, target)
+		}
+		h.Metrics.ObserveRedirect(link.Source)
+		noteRedirect(r, link, target)
+		http.Redirect(w, r, target, h.status(link))
 	} else {
-		m.fallback.ServeHTTP(w, r)
+		h.Metrics.ObserveFallback()
+		h.Fallback.ServeHTTP(w, r)
+	}
+}
+
+// target returns the URL to redirect r to, with its query
+// forwarded as per the link's policy.
+func (h *RedirectHandler) target(link *Link, r *http.Request) string {
+	policy := link.Query
+	if policy == "" {
+		policy = h.DefaultQuery
+	}
+	return applyQuery(link.Target(r.URL.EscapedPath()), r.URL.RawQuery, policy)
+}
+
+func (h *RedirectHandler) status(link *Link) int {
+	if link.Status != 0 {
+		return link.Status
+	}
+	if h.DefaultStatus != 0 {
+		return h.DefaultStatus
 	}
+	return http.StatusFound
+}
+
+// Handler will return an http.HandlerFunc that will attempt to
+// map any paths to their corresponding URL by looking them up
+// in the given Store. If the path is not found in the store,
+// then the fallback http.Handler will be called instead.
+//
+// See RedirectHandler for more options.
+func Handler(store Store, fallback http.Handler) http.HandlerFunc {
+	h := &RedirectHandler{Store: store, Fallback: fallback}
+	return http.HandlerFunc(h.redirectToPath)
 }
 
 // MapHandler will return an http.HandlerFunc (which also
@@ -45,8 +114,7 @@ func (m *mapPathHandler) redirectToPath(w http.ResponseWriter, r *http.Request)
 // If the path is not provided in the map, then the fallback
 // http.Handler will be called instead.
 func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.HandlerFunc {
-	m := mapPathHandler{pathsToUrls, fallback}
-	return http.HandlerFunc(m.redirectToPath)
+	return Handler(NewMapStore(pathsToUrls), fallback)
 }
 
 // YAMLHandler will parse the provided YAML and then return
@@ -58,21 +126,30 @@ func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.Handl
 //
 //     - path: /some-path
 //       url: https://www.some-url.com/demo
+//       status: 301 # optional
+//       query: merge # optional, see QueryPolicy
+//       expires_at: 2030-01-01T00:00:00Z # optional, or:
+//       ttl: 72h # optional, from when the YAML is parsed
+//       active_from: 2030-01-01 09:00 # optional
+//       active_until: 2030-02-01 # optional
+//       timezone: Europe/Berlin # optional, of times without offset
+//       schedule: # optional
+//         - from: 2030-01-15 09:00
+//           url: https://www.some-url.com/launch
+//       vcs: git # optional, makes the link a Go import path
 //
 // The only errors that can be returned are related to having
-// invalid YAML data.
+// invalid YAML data, including unsupported status codes and
+// duplicate paths.
 //
 // See MapHandler to create a similar http.HandlerFunc via
 // a mapping of paths to urls.
 func YAMLHandler(yml []byte, fallback http.Handler) (http.HandlerFunc, error) {
-	// parse YAML input
-	yamlPaths := []pathConfig{}
-	err := yaml.UnmarshalStrict(yml, &yamlPaths)
+	store, err := NewYAMLStore(yml)
 	if err != nil {
 		return nil, err
 	}
-	m := pathConfigToHandler(yamlPaths, fallback)
-	return http.HandlerFunc(m.redirectToPath), err
+	return Handler(store, fallback), nil
 }
 
 // JSONHandler will parse the privided JSON and return
@@ -83,45 +160,48 @@ func YAMLHandler(yml []byte, fallback http.Handler) (http.HandlerFunc, error) {
 // JSON is expected to be in the format:
 //
 //     { "path": "/some-path",
-//       "url": "https://www.some-url.com/demo" }
+//       "url": "https://www.some-url.com/demo",
+//       "status": 301,
+//       "query": "merge",
+//       "expires_at": "2030-01-01T00:00:00Z" }
 //
-// The only errors that can be returned are related to having
-// invalid JSON data.
+// where status, query and expires_at are optional, and "ttl":
+// "72h" may be given instead of expires_at. Activation windows
+// and schedules are given as in YAML. The only errors that can be
+// returned are related to having invalid JSON data, including
+// duplicate paths.
 //
 // See MapHandler to create a similar http.HandlerFunc via
 // a mapping of paths to urls.
 func JSONHandler(j []byte, fallback http.Handler) (http.HandlerFunc, error) {
-	// parse JSON input
-	jsonPaths := []pathConfig{}
-	err := json.Unmarshal(j, &jsonPaths)
+	store, err := NewJSONStore(j)
 	if err != nil {
 		return nil, err
 	}
-	m := pathConfigToHandler(jsonPaths, fallback)
-	return http.HandlerFunc(m.redirectToPath), nil
+	return Handler(store, fallback), nil
 }
 
 // BoltHandler will load url paths from the given DB instance
 // and return http.HandlerFunc. If the path is not found
 // in the db, then the fallback http.Handler will be called
 // instead.
+//
+// The paths are copied into memory when the handler is built,
+// so later changes to the DB are not served. Use Handler with a
+// BoltStore (optionally fronted by a CachedStore) to resolve
+// paths against the DB on every request.
 func BoltHandler(db *bolt.DB, fallback http.Handler) (http.HandlerFunc, error) {
-	paths := map[string]string{}
-	if err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("urlshort"))
-		if b == nil {
-			return errors.New("Db missing bucket 'urlshort'")
-		}
-		if err := b.ForEach(func(key, value []byte) error {
-			paths[string(key)] = string(value)
-			return nil
-		}); err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
+	store, err := NewBoltStore(db)
+	if err != nil {
+		return nil, err
+	}
+	links, err := store.List()
+	if err != nil {
 		return nil, err
 	}
-	m := mapPathHandler{paths, fallback}
-	return http.HandlerFunc(m.redirectToPath), nil
+	paths := NewMapStore(nil)
+	for _, link := range links {
+		paths.Put(link)
+	}
+	return Handler(paths, fallback), nil
 }
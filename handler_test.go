@@ -297,3 +297,36 @@ func TestBoltHandlerBadDB(t *testing.T) {
 		t.Errorf("Handler did not return error")
 	}
 }
+
+func TestRedirectStatus(t *testing.T) {
+	yaml := `
+- path: /permanent
+  url: https://example.com/permanent
+  status: 308
+- path: /default
+  url: https://example.com/default`
+	store, err := NewYAMLStore([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &RedirectHandler{Store: store, DefaultStatus: http.StatusSeeOther}
+
+	for path, expected := range map[string]int{
+		"/permanent": http.StatusPermanentRedirect,
+		"/default":   http.StatusSeeOther,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+		if status := rr.Result().StatusCode; status != expected {
+			t.Errorf("Handler returned wrong status code for %s: got %v want %v", path, status, expected)
+		}
+	}
+}
+
+func TestInvalidRedirectStatus(t *testing.T) {
+	json := `[{"path": "/x", "url": "https://example.com", "status": 200}]`
+
+	if _, err := JSONHandler([]byte(json), nil); err == nil {
+		t.Errorf("Handler did not return error")
+	}
+}
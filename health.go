@@ -0,0 +1,81 @@
+package urlshort
+
+import (
+	"net/http"
+	"time"
+)
+
+// Paths of the health and readiness endpoints.
+const (
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+)
+
+// Checker is implemented by stores able to report whether they
+// can serve lookups, such as BoltStore.
+type Checker interface {
+	Check() error
+}
+
+// SourceStatus is the readiness of a single source.
+type SourceStatus struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	// LastReload is when a config file source was last loaded.
+	LastReload *time.Time `json:"last_reload,omitempty"`
+	// Error is why the source is not ready or, for config
+	// files, why the last reload failed.
+	Error string `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status  string         `json:"status"`
+	Sources []SourceStatus `json:"sources"`
+}
+
+// HealthHandler will return an http.Handler reporting the process
+// is alive.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadinessHandler will return an http.Handler reporting whether
+// all sources can serve lookups, with the status of each. It
+// answers 503 Service Unavailable if any source is not ready.
+func ReadinessHandler(sources ...Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := readiness{Status: "ready", Sources: []SourceStatus{}}
+		code := http.StatusOK
+		for _, s := range sources {
+			status := sourceStatus(s)
+			if !status.Ready {
+				result.Status = "not ready"
+				code = http.StatusServiceUnavailable
+			}
+			result.Sources = append(result.Sources, status)
+		}
+		writeJSON(w, code, result)
+	})
+}
+
+func sourceStatus(s Source) SourceStatus {
+	status := SourceStatus{Name: s.Name, Ready: true}
+	switch store := s.Store.(type) {
+	case *FileStore:
+		// a file which failed to reload still serves its
+		// previous content
+		loadedAt := store.LoadedAt()
+		status.LastReload = &loadedAt
+		if err := store.LastError(); err != nil {
+			status.Error = err.Error()
+		}
+	case Checker:
+		if err := store.Check(); err != nil {
+			status.Ready = false
+			status.Error = err.Error()
+		}
+	}
+	return status
+}
@@ -0,0 +1,49 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paths.json")
+	if err := ioutil.WriteFile(path, []byte(`[]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewFileStore(path, NewJSONStoreAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := openTestDB(t)
+	store, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := ReadinessHandler(Source{"bolt", NewCachedStore(store, 0)}, Source{"json", file})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", ReadyPath, nil))
+	var result readiness
+	json.NewDecoder(rr.Result().Body).Decode(&result)
+	if rr.Result().StatusCode != http.StatusOK || result.Status != "ready" || len(result.Sources) != 2 {
+		t.Errorf("Handler returned wrong readiness: got %v %+v", rr.Result().StatusCode, result)
+	}
+	if result.Sources[1].LastReload == nil {
+		t.Errorf("Handler did not report last reload of %s", result.Sources[1].Name)
+	}
+
+	// dropping the bucket makes the bolt source unready
+	db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(bucketName) })
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", ReadyPath, nil))
+	json.NewDecoder(rr.Result().Body).Decode(&result)
+	if rr.Result().StatusCode != http.StatusServiceUnavailable || result.Sources[0].Ready {
+		t.Errorf("Handler returned wrong readiness: got %v %+v", rr.Result().StatusCode, result)
+	}
+}
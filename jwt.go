@@ -0,0 +1,449 @@
+package urlshort
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the supported algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how often a JWKS is fetched again
+// to find a key id it does not know, such as after key rotation.
+const jwksRefreshInterval = time.Minute
+
+// JWKS is a set of public keys, in the JSON Web Key Set format,
+// used to verify the signature of JWTs. It is read from a file or,
+// for sources starting with http:// or https://, fetched from a
+// URL such as an identity provider's jwks_uri.
+type JWKS struct {
+	source string
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKS returns the JWKS read from source.
+func NewJWKS(source string) (*JWKS, error) {
+	j := &JWKS{source: source, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := j.Refresh(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Refresh reads the key set from its source again.
+func (j *JWKS) Refresh() error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(j.source, "http://") || strings.HasPrefix(j.source, "https://") {
+		data, err = j.fetch()
+	} else {
+		data, err = os.ReadFile(j.source)
+	}
+	j.mu.Lock()
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot read JWKS %s: %v", j.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS %s: %v", j.source, err)
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *JWKS) fetch() ([]byte, error) {
+	res, err := j.client.Get(j.source)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// claimRefresh reports whether the set is due to be read again,
+// marking it as read now if so: concurrent requests with unknown
+// key ids, which anyone can send, start a single refresh between
+// them.
+func (j *JWKS) claimRefresh() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if time.Since(j.fetchedAt) <= jwksRefreshInterval {
+		return false
+	}
+	j.fetchedAt = time.Now()
+	return true
+}
+
+// candidates returns the keys which may have signed a token with
+// the key id kid: that key, or all keys if kid is empty. An
+// unknown kid makes the set be read again, at most once every
+// jwksRefreshInterval.
+func (j *JWKS) candidates(kid string) []crypto.PublicKey {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	j.mu.RUnlock()
+	if kid != "" && !ok && j.claimRefresh() {
+		j.Refresh()
+		j.mu.RLock()
+		key, ok = j.keys[kid]
+		j.mu.RUnlock()
+	}
+	if ok {
+		return []crypto.PublicKey{key}
+	}
+	if kid != "" {
+		return nil
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	keys := make([]crypto.PublicKey, 0, len(j.keys))
+	for _, key := range j.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signature keys of a JSON Web Key Set by
+// key id. Keys of unsupported types are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifySignature checks sig is the signature of signed by key
+// with the JWS algorithm alg.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	digest := func() []byte {
+		h := hash.New()
+		h.Write(signed)
+		return h.Sum(nil)
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512":
+			return rsa.VerifyPKCS1v15(k, hash, digest(), sig) == nil
+		case "PS256", "PS384", "PS512":
+			return rsa.VerifyPSS(k, hash, digest(), sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		want := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[k.Curve.Params().Name]
+		if alg != want || len(sig) != 2*size {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest(), r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, sig)
+	}
+	return false
+}
+
+// JWTAuthenticator authenticates requests bearing a JWT signed by
+// one of the keys of a JWKS, such as an ID token of an OIDC
+// provider. The user and groups claims of the token name the
+// Principal, which is granted the scopes of its groups.
+type JWTAuthenticator struct {
+	Keys *JWKS
+	// Issuer and Audience, if set, must match the iss and aud
+	// claims of the token. Without them any token signed by the
+	// keys is accepted, including those an identity provider
+	// issues for other applications.
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway given when checking the exp, nbf
+	// and iat claims.
+	ClockSkew time.Duration
+	// UserClaim names the claim identifying the user, "email"
+	// if empty.
+	UserClaim string
+	// GroupsClaim names the claim listing the user's groups,
+	// "groups" if empty.
+	GroupsClaim string
+	// Roles maps groups to the scopes they grant. The scopes of
+	// the "*" group are granted to every user.
+	Roles map[string][]Scope
+}
+
+// Authenticate implements Authenticator for requests with an
+// "Authorization: Bearer <jwt>" header.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+	claims, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	return a.principal(claims)
+}
+
+// verify checks the signature and time, issuer and audience
+// claims of token, returning its claims.
+func (a *JWTAuthenticator) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	if len(header.Alg) < 5 && header.Alg != "EdDSA" {
+		// rejects "none" in particular
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid signature encoding")
+	}
+	verified := false
+	for _, key := range a.Keys.candidates(header.Kid) {
+		if verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("missing exp claim")
+	}
+	if !now.Add(-a.ClockSkew).Before(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token expired")
+	}
+	for _, claim := range []string{"nbf", "iat"} {
+		if t, ok := claims[claim].(float64); ok && now.Add(a.ClockSkew).Before(time.Unix(int64(t), 0)) {
+			return nil, fmt.Errorf("token not valid yet (%s)", claim)
+		}
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if a.Audience != "" && !containsClaim(claims["aud"], a.Audience) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) principal(claims map[string]interface{}) (*Principal, error) {
+	userClaim, groupsClaim := a.UserClaim, a.GroupsClaim
+	if userClaim == "" {
+		userClaim = "email"
+	}
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	user, _ := claims[userClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrUnauthorized, userClaim)
+	}
+	p := &Principal{Subject: "user:" + user, Name: user}
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		p.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				p.Groups = append(p.Groups, s)
+			}
+		}
+	}
+	seen := map[Scope]bool{}
+	for _, group := range append([]string{"*"}, p.Groups...) {
+		for _, scope := range a.Roles[group] {
+			if !seen[scope] {
+				seen[scope] = true
+				p.Scopes = append(p.Scopes, scope)
+			}
+		}
+	}
+	return p, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// containsClaim reports whether an aud style claim, a string or a
+// list of strings, contains want.
+func containsClaim(claim interface{}, want string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == want
+	case []interface{}:
+		for _, v := range c {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseRoles parses the scopes granted to groups in the format
+// "group=scope+scope,other=scope", as used for
+// JWTAuthenticator.Roles. A Role may stand for its scopes, as in
+// "eng=editor".
+func ParseRoles(rules string) (map[string][]Scope, error) {
+	roles := map[string][]Scope{}
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid role rule %q, expected group=scope+scope", rule)
+		}
+		group := rule[:i]
+		for _, s := range strings.Split(rule[i+1:], "+") {
+			scope := Scope(strings.TrimSpace(s))
+			if scopes := Role(scope).Scopes(); scopes != nil {
+				roles[group] = append(roles[group], scopes...)
+				continue
+			}
+			if !scope.Valid() {
+				return nil, fmt.Errorf("invalid scope %q for group %s", scope, group)
+			}
+			roles[group] = append(roles[group], scope)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,206 @@
+package urlshort
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// signJWT returns a token of claims signed with key under kid.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+	ed  ed25519.PrivateKey
+}
+
+// writeTestJWKS writes a JWKS of freshly generated keys to a file.
+func writeTestJWKS(t *testing.T) (*testKeys, string) {
+	keys := &testKeys{}
+	var err error
+	if keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if keys.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if _, keys.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64.EncodeToString(keys.rsa.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(keys.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64.EncodeToString(keys.ec.X.FillBytes(make([]byte, 32))),
+			"y": b64.EncodeToString(keys.ec.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(keys.ed.Public().(ed25519.PublicKey))},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keys, path
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	keys, path := writeTestJWKS(t)
+	jwks, err := NewJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, err := ParseRoles("*=links:read, admins=admin, eng=links:write+stats:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &JWTAuthenticator{Keys: jwks, Issuer: "https://idp.example.com", Audience: "urlshort",
+		ClockSkew: time.Minute, Roles: roles}
+
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://idp.example.com", "aud": []string{"other", "urlshort"},
+			"exp": now + 60, "iat": now, "email": "ann@example.com", "groups": []string{"eng"}}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	authenticate := func(token string) (*Principal, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return auth.Authenticate(req)
+	}
+
+	for alg, key := range map[string]crypto.Signer{"RS256": keys.rsa, "ES256": keys.ec, "EdDSA": keys.ed} {
+		kid := map[string]string{"RS256": "rsa", "ES256": "ec", "EdDSA": "ed"}[alg]
+		p, err := authenticate(signJWT(t, alg, kid, key, claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if p.Subject != "user:ann@example.com" || !p.HasScope(ScopeReadLinks) || !p.HasScope(ScopeWriteLinks) ||
+			!p.HasScope(ScopeReadStats) || p.HasScope(ScopeAdmin) {
+			t.Errorf("%s: unexpected principal %+v", alg, p)
+		}
+	}
+
+	for name, token := range map[string]string{
+		"expired":        signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"exp": now - 120})),
+		"not yet valid":  signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"nbf": now + 120})),
+		"wrong issuer":   signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"wrong audience": signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"aud": "other"})),
+		"no exp":         signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"exp": nil})),
+		"no user":        signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"email": nil})),
+		"wrong key":      signJWT(t, "ES256", "rsa", keys.ec, claims(nil)),
+		"alg mismatch":   signJWT(t, "RS256", "ec", keys.rsa, claims(nil)),
+		"alg none":       b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"email":"x"}`)) + ".",
+	} {
+		if p, err := authenticate(token); err == nil || !errors.Is(err, ErrUnauthorized) || p != nil {
+			t.Errorf("%s: expected the token rejected, got %+v, %v", name, p, err)
+		}
+	}
+
+	if p, err := authenticate(signJWT(t, "ES256", "ec", keys.ec, claims(map[string]interface{}{"exp": now - 30}))); err != nil || p == nil {
+		t.Errorf("Expected a token expired within the clock skew accepted, got %v", err)
+	}
+	if p, err := authenticate("usk_abc_def"); p != nil || err != nil {
+		t.Errorf("Expected API keys ignored, got %v, %v", p, err)
+	}
+}
+
+func TestJWKSURL(t *testing.T) {
+	_, path := writeTestJWKS(t)
+	data, _ := os.ReadFile(path)
+	fetches := 0
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	jwks, err := NewJWKS(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.candidates("ec")) != 1 || len(jwks.candidates("")) != 3 {
+		t.Errorf("Expected the keys fetched, got %v", jwks.keys)
+	}
+	jwks.candidates("unknown")
+	if fetches != 1 {
+		t.Errorf("Expected unknown keys not to refetch within the refresh interval, got %d fetches", fetches)
+	}
+
+	// a stale set is read again once, however many requests
+	// with unknown keys arrive during the refresh
+	jwks.mu.Lock()
+	jwks.fetchedAt = time.Time{}
+	jwks.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			jwks.candidates(fmt.Sprintf("unknown-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 2 {
+		t.Errorf("Expected a single refetch, got %d fetches", fetches-1)
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	for _, rules := range []string{"admins", "=admin", "admins=root"} {
+		if _, err := ParseRoles(rules); err == nil {
+			t.Errorf("Expected an error for %q", rules)
+		}
+	}
+}
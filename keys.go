@@ -0,0 +1,263 @@
+package urlshort
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var keysBucketName = []byte("urlshort-keys")
+
+// KeysPrefix is the path under which KeysHandler expects to be
+// mounted.
+const KeysPrefix = "/api/keys"
+
+// keyPrefix starts every API key, telling them apart from other
+// bearer tokens.
+const keyPrefix = "usk_"
+
+// ErrKeyNotFound is returned when revoking an unknown API key.
+var ErrKeyNotFound = errors.New("no such API key")
+
+// APIKey describes an API key. The key itself is only known to
+// its holder.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type keyRecord struct {
+	APIKey
+	// Hash is the SHA-256 of the key's secret, which is random
+	// enough not to need a slow hash.
+	Hash string `json:"hash"`
+}
+
+// KeyStore keeps API keys in the 'urlshort-keys' bucket of a bolt
+// DB. Only hashes of the keys are stored.
+type KeyStore struct {
+	db *bolt.DB
+}
+
+// NewKeyStore returns a KeyStore keeping keys in db, creating the
+// keys bucket if needed.
+func NewKeyStore(db *bolt.DB) (*KeyStore, error) {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(keysBucketName)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &KeyStore{db}, nil
+}
+
+// Create adds a key granting scopes, returning it along with the
+// key to hand to its holder, in the format "usk_<id>_<secret>".
+func (k *KeyStore) Create(name string, scopes []Scope) (APIKey, string, error) {
+	if err := validScopes(scopes); err != nil {
+		return APIKey{}, "", err
+	}
+	id, secret := make([]byte, 8), make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	record := keyRecord{
+		APIKey: APIKey{
+			ID:        hex.EncodeToString(id),
+			Name:      name,
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+		},
+		Hash: hashSecret(hex.EncodeToString(secret)),
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if err := k.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucketName).Put([]byte(record.ID), value)
+	}); err != nil {
+		return APIKey{}, "", err
+	}
+	return record.APIKey, keyPrefix + record.ID + "_" + hex.EncodeToString(secret), nil
+}
+
+func validScopes(scopes []Scope) error {
+	if len(scopes) == 0 {
+		return errors.New("a key needs at least one scope")
+	}
+	for _, s := range scopes {
+		if !s.Valid() {
+			return fmt.Errorf("invalid scope %q", s)
+		}
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Revoke deletes the key with the given id.
+func (k *KeyStore) Revoke(id string) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(keysBucketName)
+		if b.Get([]byte(id)) == nil {
+			return ErrKeyNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// List returns all keys, ordered by id.
+func (k *KeyStore) List() ([]APIKey, error) {
+	keys := []APIKey{}
+	err := k.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucketName).ForEach(func(_, value []byte) error {
+			var record keyRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			keys = append(keys, record.APIKey)
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// Authenticate implements Authenticator for requests with an
+// "Authorization: Bearer <key>" header. So that browsers can use
+// keys too, the key is also accepted as the password of Basic
+// authentication.
+func (k *KeyStore) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+	}
+	if !strings.HasPrefix(token, keyPrefix) {
+		return nil, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, keyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrUnauthorized
+	}
+	var record keyRecord
+	if err := k.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(keysBucketName).Get([]byte(parts[0]))
+		if value == nil {
+			return ErrUnauthorized
+		}
+		return json.Unmarshal(value, &record)
+	}); err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(record.Hash)) != 1 {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{Subject: "key:" + record.ID, Name: record.Name, Scopes: record.Scopes}, nil
+}
+
+// bearerToken returns the token of the request's "Authorization:
+// Bearer" header, if any.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+type keyRequest struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+type createdKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// KeysHandler will return an http.Handler serving a JSON API to
+// manage API keys. It must be mounted at KeysPrefix, and should
+// be protected with ScopeAdmin. It supports:
+//
+//     GET    /api/keys        list all keys
+//     POST   /api/keys        create a key, returning it once
+//     DELETE /api/keys/<id>   revoke a key
+//
+// Keys are created from JSON objects in the format:
+//
+//     { "name": "deploy bot", "scopes": ["links:read", "links:write"] }
+func KeysHandler(keys *KeyStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, KeysPrefix), "/")
+		if id != "" {
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			err := keys.Revoke(id)
+			if err == ErrKeyNotFound {
+				writeJSONError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			list, err := keys.List()
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+		case http.MethodPost:
+			var req keyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %v", err))
+				return
+			}
+			if req.Name == "" {
+				writeJSONError(w, http.StatusBadRequest, "a key needs a name")
+				return
+			}
+			if err := validScopes(req.Scopes); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			key, token, err := keys.Create(req.Name, req.Scopes)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, createdKey{key, token})
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	keys, err := NewKeyStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keys.Create("bad", []Scope{"links:delete"}); err == nil {
+		t.Error("Expected an error for an unknown scope")
+	}
+	key, token, err := keys.Create("deploy", []Scope{ScopeReadLinks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "usk_"+key.ID+"_") {
+		t.Errorf("Unexpected key format %q", token)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	p, err := keys.Authenticate(req)
+	if err != nil || p == nil || p.Name != "deploy" || !p.HasScope(ScopeReadLinks) || p.HasScope(ScopeWriteLinks) {
+		t.Errorf("Unexpected principal %+v, %v", p, err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("", token)
+	if p, err := keys.Authenticate(req); err != nil || p == nil {
+		t.Errorf("Expected the key accepted as a Basic password, got %v", err)
+	}
+	for _, bad := range []string{token + "x", "usk_" + key.ID, "usk_nope_secret"} {
+		req.Header.Set("Authorization", "Bearer "+bad)
+		if p, err := keys.Authenticate(req); err == nil || p != nil {
+			t.Errorf("Expected %q rejected", bad)
+		}
+	}
+	req.Header.Set("Authorization", "Bearer some.jwt.token")
+	if p, err := keys.Authenticate(req); err != nil || p != nil {
+		t.Errorf("Expected other tokens ignored, got %v, %v", p, err)
+	}
+
+	if err := keys.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if p, _ := keys.Authenticate(req); p != nil {
+		t.Error("Expected a revoked key rejected")
+	}
+	if err := keys.Revoke(key.ID); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	keys, err := NewKeyStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reader, _ := keys.Create("reader", []Scope{ScopeReadLinks})
+	_, admin, _ := keys.Create("admin", []Scope{ScopeAdmin})
+	handler := Protect(keys, ScopeReadLinks, ScopeWriteLinks, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(PrincipalFrom(r).Name))
+	}))
+
+	for _, c := range []struct {
+		method, token string
+		status        int
+	}{
+		{"GET", "", http.StatusUnauthorized},
+		{"GET", reader, http.StatusOK},
+		{"POST", reader, http.StatusForbidden},
+		{"POST", admin, http.StatusOK},
+	} {
+		req := httptest.NewRequest(c.method, "/", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != c.status {
+			t.Errorf("%s with %q: expected %d, got %d", c.method, c.token, c.status, rr.Code)
+		}
+		if rr.Code == http.StatusUnauthorized && len(rr.Header().Values("WWW-Authenticate")) == 0 {
+			t.Error("Expected a WWW-Authenticate challenge")
+		}
+	}
+}
+
+func TestKeysHandler(t *testing.T) {
+	keys, err := NewKeyStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := KeysHandler(keys)
+
+	res := doAPIRequest(handler, "POST", KeysPrefix, `{"name": "ci", "scopes": ["links:write"]}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", res.StatusCode)
+	}
+	var created createdKey
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" || created.Name != "ci" {
+		t.Errorf("Unexpected created key %+v", created)
+	}
+
+	res = doAPIRequest(handler, "GET", KeysPrefix, "")
+	var list []map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0]["hash"] != nil || list[0]["key"] != nil {
+		t.Errorf("Expected one key listed without its secret, got %v", list)
+	}
+
+	if res := doAPIRequest(handler, "POST", KeysPrefix, `{"name": "ci", "scopes": []}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a key without scopes, got %d", res.StatusCode)
+	}
+	if res := doAPIRequest(handler, "DELETE", KeysPrefix+"/"+created.ID, ""); res.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 revoking a key, got %d", res.StatusCode)
+	}
+	if res := doAPIRequest(handler, "DELETE", KeysPrefix+"/"+created.ID, ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking a revoked key, got %d", res.StatusCode)
+	}
+}
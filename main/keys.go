@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/asfaltboy/urlshort"
+	bolt "go.etcd.io/bbolt"
+)
+
+const keysUsage = `usage: urlshort -db <file> keys <command>
+
+commands:
+  create -name <name> -scopes <scope,...>   create a key and print it
+  list                                      list keys
+  revoke <id>                               revoke a key
+
+scopes: links:read, links:write, stats:read, admin
+        or the roles viewer, editor, admin`
+
+// runKeys runs the keys subcommand on the bolt db at dbFile. The db
+// can not be opened while a server is using it.
+func runKeys(dbFile string, args []string) error {
+	if dbFile == "" || len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("cannot open database file '%s' (is a server using it?): %v", dbFile, err)
+	}
+	defer db.Close()
+	keys, err := urlshort.NewKeyStore(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		name := fs.String("name", "", "Name of the key, such as who holds it")
+		scopes := fs.String("scopes", "", "Comma separated scopes of the key")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" {
+			return errors.New("a key needs a -name")
+		}
+		list := []urlshort.Scope{}
+		for _, s := range strings.Split(*scopes, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			} else if scopes := urlshort.Role(s).Scopes(); scopes != nil {
+				list = append(list, scopes...)
+			} else {
+				list = append(list, urlshort.Scope(s))
+			}
+		}
+		key, token, err := keys.Create(*name, list)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "created key %s, it is not shown again:\n", key.ID)
+		fmt.Println(token)
+	case "list":
+		list, err := keys.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED")
+		for _, key := range list {
+			scopes := make([]string, len(key.Scopes))
+			for i, s := range key.Scopes {
+				scopes[i] = string(s)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(scopes, ","), key.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		if err := keys.Revoke(args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "revoked key %s\n", args[1])
+	default:
+		return errors.New(keysUsage)
+	}
+	return nil
+}
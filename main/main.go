@@ -1,78 +1,410 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/asfaltboy/urlshort"
 	bolt "go.etcd.io/bbolt"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+// run serves until the server fails or is shut down, returning
+// once the deferred closes of the db and its writers have run.
+func run() error {
+	addr := flag.String("addr", envOr("URLSHORT_ADDR", ":8080"), "Address to listen on ($URLSHORT_ADDR)")
+	tlsCert := flag.String("tls-cert", envOr("URLSHORT_TLS_CERT", ""), "Path to TLS certificate file, serves HTTPS if set with -tls-key ($URLSHORT_TLS_CERT)")
+	tlsKey := flag.String("tls-key", envOr("URLSHORT_TLS_KEY", ""), "Path to TLS key file ($URLSHORT_TLS_KEY)")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "Maximum duration for reading a request")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "Maximum duration for writing a response")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "Maximum time to keep idle connections open")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to drain connections on SIGINT or SIGTERM")
 	dbFile := flag.String("db", "example.db", "Path to bold db file (see https://godoc.org/go.etcd.io/bbolt)")
 	yaml := flag.String("yaml", "example.yaml", "Path to yaml config file (see example.yaml)")
 	json := flag.String("json", "", "Path to yaml config file (see example.yaml)")
+	boltCache := flag.Duration("bolt-cache", 0, "Cache bolt lookups for this long (0 disables the cache)")
+	api := flag.Bool("api", false, "Serve the JSON links API under /api/links (requires -db)")
+	auth := flag.Bool("auth", true, "Require API keys (see the keys command) or JWTs for the API, UI and stats endpoints")
+	jwks := flag.String("jwks", "", "JWKS file or URL verifying JWTs accepted besides API keys, such as an OIDC provider's jwks_uri")
+	jwtIssuer := flag.String("jwt-issuer", "", "Required issuer (iss) of JWTs (required with -jwks)")
+	jwtAudience := flag.String("jwt-audience", "", "Required audience (aud) of JWTs, such as the client ID of urlshort (required with -jwks)")
+	jwtSkew := flag.Duration("jwt-skew", time.Minute, "Clock skew allowed checking the times of JWTs")
+	jwtUser := flag.String("jwt-user-claim", "email", "JWT claim naming the user")
+	jwtGroups := flag.String("jwt-groups-claim", "groups", "JWT claim listing the user's groups")
+	jwtRoles := flag.String("jwt-roles", "", "Scopes or roles (viewer, editor, admin) granted to JWT groups, as group=scope+scope,... (group * for all users)")
+	ui := flag.Bool("ui", false, "Serve a web interface to browse links under /ui, and to manage those of the bolt db")
+	codeAlphabet := flag.String("code-alphabet", urlshort.Base62Alphabet, "Characters of generated short codes")
+	codeLength := flag.Int("code-length", 6, "Length of generated short codes")
+	codeSequential := flag.Bool("code-sequential", false, "Generate short codes from a sequence instead of at random")
+	watch := flag.Duration("watch", 2*time.Second, "Check config files for changes this often (0 disables, SIGHUP always reloads)")
+	status := flag.Int("status", http.StatusFound, "Redirect status code of links without one (301, 302, 303, 307 or 308)")
+	query := flag.String("query", string(urlshort.QueryDrop), "Query string policy of links without one (drop, append, merge or target-wins)")
+	gonePage := flag.String("gone-page", "", "Path to an HTML page served with 410 Gone for expired links")
+	sweepExpired := flag.Duration("sweep-expired", 30*24*time.Hour, "Delete links from the bolt db this long after they expired (0 keeps them)")
+	lenient := flag.Bool("lenient", false, "Warn about paths defined twice in a config file instead of failing")
+	clicks := flag.Duration("clicks", 5*time.Second, "Write click counts to the bolt db this often (0 disables counting)")
+	events := flag.Duration("events", 0, "Write a click event log to the bolt db this often (0 disables the log)")
+	eventsRetention := flag.Duration("events-retention", 90*24*time.Hour, "Purge click events older than this (0 keeps them forever)")
+	metrics := flag.Bool("metrics", true, "Serve Prometheus metrics at /metrics")
+	accessLog := flag.String("access-log", "", "Log every request to stdout in this format: json or logfmt (empty disables the log)")
+	accessSample := flag.String("access-log-sample", "", "Fraction of requests to log per path, as /path=rate,... (0 disables logging the path)")
 	flag.Parse()
 
+	if flag.Arg(0) == "keys" {
+		return runKeys(*dbFile, flag.Args()[1:])
+	}
+
 	if *yaml == "" && *json == "" && *dbFile == "" {
-		log.Fatal("Must provide one source for path")
+		return errors.New("Must provide one source for path")
+	}
+	if !urlshort.ValidRedirectStatus(*status) {
+		return fmt.Errorf("Invalid redirect status %d", *status)
+	}
+	if !urlshort.QueryPolicy(*query).Valid() {
+		return fmt.Errorf("Invalid query policy %q", *query)
+	}
+	if *api && *dbFile == "" {
+		return errors.New("The links API requires a bolt db")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("Must provide both -tls-cert and -tls-key to serve HTTPS")
+	}
+	if *accessLog != "" && *accessLog != urlshort.JSONFormat && *accessLog != urlshort.LogfmtFormat {
+		return fmt.Errorf("Invalid access log format %q", *accessLog)
+	}
+	sample, err := urlshort.ParseSample(*accessSample)
+	if err != nil {
+		return err
+	}
+	roles, err := urlshort.ParseRoles(*jwtRoles)
+	if err != nil {
+		return err
 	}
 
-	var mux http.Handler
-	var err error
-	mux = http.Handler(defaultMux())
+	root := http.NewServeMux()
+	// sources in order of precedence, the bolt db first
+	sources := []urlshort.Source{}
+	var codes *urlshort.CodeGenerator
+	authenticators := urlshort.Authenticators{}
+	// set once protect is used, which requires authenticators
+	protected := false
+	// protect requires the read scope to GET h and the write
+	// scope for any other method, unless auth is disabled
+	protect := func(read, write urlshort.Scope, h http.Handler) http.Handler {
+		if !*auth {
+			return h
+		}
+		protected = true
+		return urlshort.Protect(authenticators, read, write, h)
+	}
+	if *auth && *jwks != "" {
+		if *jwtAudience == "" || *jwtIssuer == "" {
+			return errors.New("-jwks requires -jwt-audience and -jwt-issuer, lest tokens issued for other applications be accepted")
+		}
+		keys, err := urlshort.NewJWKS(*jwks)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, &urlshort.JWTAuthenticator{
+			Keys:        keys,
+			Issuer:      *jwtIssuer,
+			Audience:    *jwtAudience,
+			ClockSkew:   *jwtSkew,
+			UserClaim:   *jwtUser,
+			GroupsClaim: *jwtGroups,
+			Roles:       roles,
+		})
+	}
 
 	if *yaml != "" {
-		mux, err = urlshort.YAMLHandler(readFileContent(*yaml), mux)
+		store, err := urlshort.NewFileStore(*yaml, parser(urlshort.NewYAMLStoreAt, *yaml, *lenient))
 		if err != nil {
-			log.Fatalf("cannot build yaml handler: %v", err)
+			return fmt.Errorf("cannot build yaml handler: %v", err)
 		}
+		sources = append([]urlshort.Source{{Name: "yaml", Store: store}}, sources...)
 	}
 	if *json != "" {
-		mux, err = urlshort.JSONHandler(readFileContent(*json), mux)
+		store, err := urlshort.NewFileStore(*json, parser(urlshort.NewJSONStoreAt, *json, *lenient))
 		if err != nil {
-			log.Fatalf("cannot build json handler: %v", err)
+			return fmt.Errorf("cannot build json handler: %v", err)
 		}
+		sources = append([]urlshort.Source{{Name: "json", Store: store}}, sources...)
 	}
+
+	var db *bolt.DB
 	if *dbFile != "" {
-		db, err := bolt.Open(*dbFile, 0600, nil)
+		var err error
+		db, err = bolt.Open(*dbFile, 0600, nil)
 		if err != nil {
-			log.Fatalf("error reading database file '%s': %v", *dbFile, err)
+			return fmt.Errorf("error reading database file '%s': %v", *dbFile, err)
 		}
 		defer db.Close()
-		mux, err = urlshort.BoltHandler(db, mux)
+		boltStore, err := urlshort.NewBoltStore(db)
+		if err != nil {
+			return fmt.Errorf("cannot build bolt handler: %v", err)
+		}
+		if *auth {
+			keys, err := urlshort.NewKeyStore(db)
+			if err != nil {
+				return fmt.Errorf("cannot open API keys: %v", err)
+			}
+			authenticators = append(authenticators, keys)
+			keysHandler := protect(urlshort.ScopeAdmin, urlshort.ScopeAdmin, urlshort.KeysHandler(keys))
+			root.Handle(urlshort.KeysPrefix, keysHandler)
+			root.Handle(urlshort.KeysPrefix+"/", keysHandler)
+		}
+		var store urlshort.Store = boltStore
+		if *boltCache > 0 {
+			store = urlshort.NewCachedStore(store, *boltCache)
+		}
+		audit, err := urlshort.NewAuditLog(db)
+		if err != nil {
+			return fmt.Errorf("cannot open audit log: %v", err)
+		}
+		boltStore.Audit = audit
+		root.Handle(urlshort.AuditPrefix, protect(urlshort.ScopeAdmin, urlshort.ScopeAdmin, urlshort.AuditHandler(audit)))
+		sources = append([]urlshort.Source{{Name: "bolt", Store: store}}, sources...)
+		if *sweepExpired > 0 {
+			go boltStore.Sweep(time.Hour, *sweepExpired, nil)
+		}
+		codes = urlshort.NewCodeGenerator(store)
+		codes.Alphabet = *codeAlphabet
+		codes.Length = *codeLength
+		codes.Sequential = *codeSequential
+		codes.Sequence = boltStore
+		codes.Config = sources[1:]
+		if *api {
+			apiHandler := protect(urlshort.ScopeReadLinks, urlshort.ScopeWriteLinks, urlshort.APIHandler(store, codes, sources[1:]...))
+			root.Handle(urlshort.APIPrefix, apiHandler)
+			root.Handle(urlshort.APIPrefix+"/", apiHandler)
+		}
+	}
+
+	links := urlshort.NewMultiStore(sources...)
+	if err := logConflicts(links); err != nil {
+		return err
+	}
+	var m *urlshort.Metrics
+	if *metrics {
+		m = urlshort.NewMetrics(sources...)
+		root.Handle(urlshort.MetricsPath, m)
+	}
+	watchFiles(sources, *watch, m)
+	root.Handle(urlshort.HealthPath, urlshort.HealthHandler())
+	root.Handle(urlshort.ReadyPath, urlshort.ReadinessHandler(sources...))
+	createURL := ""
+	if *ui {
+		uiHandler := protect(urlshort.ScopeReadLinks, urlshort.ScopeWriteLinks, urlshort.UIHandler(links, "bolt", codes))
+		root.Handle(urlshort.UIPrefix, uiHandler)
+		root.Handle(urlshort.UIPrefix+"/", uiHandler)
+		if db != nil {
+			createURL = urlshort.UIPrefix + "/new"
+		}
+	}
+	redirects := &urlshort.RedirectHandler{
+		Store:         links,
+		Fallback:      defaultMux(links, createURL, m),
+		DefaultStatus: *status,
+		DefaultQuery:  urlshort.QueryPolicy(*query),
+		Metrics:       m,
+	}
+	if *gonePage != "" {
+		page, err := os.ReadFile(*gonePage)
+		if err != nil {
+			return fmt.Errorf("cannot read gone page: %v", err)
+		}
+		redirects.Gone = gone(page)
+	}
+	if db != nil && *clicks > 0 {
+		counter, err := urlshort.NewClickCounter(db, *clicks)
+		if err != nil {
+			return fmt.Errorf("cannot count clicks: %v", err)
+		}
+		defer counter.Close()
+		redirects.Recorders = append(redirects.Recorders, counter)
+		stats := protect(urlshort.ScopeReadStats, urlshort.ScopeReadStats, urlshort.StatsHandler(counter))
+		root.Handle(urlshort.StatsPrefix, stats)
+		root.Handle(urlshort.StatsPrefix+"/", stats)
+	}
+	if db != nil && *events > 0 {
+		eventLog, err := urlshort.NewEventLog(db, *events, *eventsRetention)
 		if err != nil {
-			log.Fatalf("cannot build bolt handler: %v", err)
+			return fmt.Errorf("cannot log click events: %v", err)
 		}
+		defer eventLog.Close()
+		redirects.Recorders = append(redirects.Recorders, eventLog)
+		root.Handle(urlshort.EventsPrefix, protect(urlshort.ScopeReadStats, urlshort.ScopeReadStats, urlshort.EventsHandler(eventLog)))
+	}
+	root.Handle("/", redirects)
+	if protected && len(authenticators) == 0 {
+		return errors.New("Authentication requires a bolt db for API keys or -jwks, or -auth=false")
+	}
+
+	var handler http.Handler = root
+	if *accessLog != "" {
+		logger := &urlshort.AccessLogger{Out: os.Stdout, Format: *accessLog, Sample: sample}
+		handler = logger.Handler(root)
+	}
+	srv := &http.Server{
+		Addr:         *addr,
+		Handler:      handler,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	if *tlsCert != "" {
+		certs, err := newCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			return fmt.Errorf("cannot load TLS certificate: %v", err)
+		}
+		if *watch > 0 {
+			go certs.watch(*watch)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+
+	// the deferred closes of the db and its writers run once
+	// serve returns, after in-flight requests are drained
+	if err := serve(srv, *shutdownTimeout); err != nil {
+		return fmt.Errorf("server error: %v", err)
+	}
+	return nil
+}
+
+// serve runs srv until it fails or the process receives SIGINT or
+// SIGTERM, in which case it stops accepting connections and waits
+// up to timeout for active requests to complete.
+func serve(srv *http.Server, timeout time.Duration) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	shutdown := make(chan error, 1)
+	go func() {
+		sig := <-stop
+		log.Printf("received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	var err error
+	if srv.TLSConfig != nil {
+		fmt.Printf("Starting the server on %s (HTTPS)\n", srv.Addr)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		fmt.Printf("Starting the server on %s\n", srv.Addr)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return err
 	}
+	return <-shutdown
+}
 
-	fmt.Println("Starting the server on :8080")
-	http.ListenAndServe(":8080", mux)
+// envOr returns the value of the environment variable name, or
+// def if it is not set.
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
 }
 
-func defaultMux() *http.ServeMux {
+func defaultMux(links urlshort.Store, createURL string, metrics *urlshort.Metrics) *http.ServeMux {
+	notFound := urlshort.NotFoundHandler(links, createURL)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", notFound)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		metrics.ObserveNotFound()
+		notFound.ServeHTTP(w, r)
+	})
 	return mux
 }
 
-func notFound(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintln(w, "Unknown urlshort entry!")
+// gone returns a handler answering 410 Gone with the given HTML page.
+func gone(page []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusGone)
+		w.Write(page)
+	}
 }
 
-func readFileContent(path string) []byte {
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("could not open config file %s: %v", path, err)
+// parser returns the parse function for the config file at path,
+// logging duplicate paths instead of failing if lenient.
+func parser(parse func([]byte, time.Time) (*urlshort.MapStore, error), path string, lenient bool) func([]byte, time.Time) (*urlshort.MapStore, error) {
+	if !lenient {
+		return parse
+	}
+	return func(content []byte, modTime time.Time) (*urlshort.MapStore, error) {
+		parseAt := func(content []byte) (*urlshort.MapStore, error) {
+			return parse(content, modTime)
+		}
+		return urlshort.Lenient(parseAt, func(err error) {
+			log.Printf("warning: config file %s: %v", path, err)
+		})(content)
 	}
-	content, err := ioutil.ReadAll(file)
+}
+
+// logConflicts logs the paths defined in more than one source,
+// and which source is used for them.
+func logConflicts(links *urlshort.MultiStore) error {
+	conflicts, err := links.Conflicts()
 	if err != nil {
-		log.Fatalf("could not read config file: %v", err)
+		return fmt.Errorf("cannot list links: %v", err)
 	}
-	return content
+	for _, c := range conflicts {
+		log.Printf("path %s is defined in %s, using %s", c.Path, strings.Join(c.Sources, ", "), c.Winner())
+	}
+	return nil
+}
+
+// watchFiles reloads the config file sources when they change on
+// disk (if interval is not 0) or when the process receives SIGHUP.
+// Invalid files are logged and the previous content kept.
+func watchFiles(sources []urlshort.Source, interval time.Duration, metrics *urlshort.Metrics) {
+	files := []*urlshort.FileStore{}
+	for _, s := range sources {
+		f, ok := s.Store.(*urlshort.FileStore)
+		if !ok {
+			continue
+		}
+		name := s.Name
+		files = append(files, f)
+		f.OnReload = func(err error) {
+			metrics.ObserveReload(name, err)
+			if err != nil {
+				log.Printf("could not reload config file %s, keeping previous links: %v", f.Path(), err)
+			} else {
+				log.Printf("reloaded config file %s", f.Path())
+			}
+		}
+		if interval > 0 {
+			go f.Watch(interval, nil)
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, f := range files {
+				f.Reload()
+			}
+		}
+	}()
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a TLS certificate loaded from files,
+// reloading it when either file changes so renewed certificates
+// are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// latestModTime returns the most recent modification time of the
+// certificate and key files.
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert, c.modTime = &cert, modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// watch reloads the certificate every interval if its files
+// changed. A certificate which can not be loaded, such as one
+// caught half-written, is logged and the previous one kept.
+func (c *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		modTime, err := c.latestModTime()
+		c.mu.RLock()
+		changed := err == nil && !modTime.Equal(c.modTime)
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := c.load(); err != nil {
+			log.Printf("could not reload TLS certificate, keeping previous one: %v", err)
+		} else {
+			log.Printf("reloaded TLS certificate %s", c.certFile)
+		}
+	}
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
@@ -0,0 +1,203 @@
+package urlshort
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// placeholder matches a "{name}" template placeholder.
+var placeholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// IsWildcard reports whether the link is a prefix link, with a
+// path ending in "/*". Such a link matches its prefix and any path
+// below it, and forwards the rest of the path to its URL.
+func (l *Link) IsWildcard() bool {
+	return strings.HasSuffix(l.Path, "/*")
+}
+
+// IsTemplate reports whether the link is a template link, with
+// "{name}" placeholders as whole path segments, such as
+// "/pr/{repo}/{num}". Each placeholder matches a single segment of
+// the request path, whose value is substituted for the same
+// placeholder in the URL.
+func (l *Link) IsTemplate() bool {
+	return placeholder.MatchString(l.Path)
+}
+
+// Target returns the URL to redirect the request path to, given
+// in its escaped form as by url.URL.EscapedPath. The part of the
+// path a wildcard link forwards stays escaped, so it can not add
+// a query, fragment or path segments to the URL.
+func (l *Link) Target(path string) string {
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		decoded = path
+	}
+	if l.IsTemplate() {
+		return l.expand(l.matchTemplate(decoded))
+	}
+	if !l.IsWildcard() || l.IsGoImport() {
+		return l.URL
+	}
+	prefix := strings.TrimSuffix(l.Path, "*")
+	suffix := ""
+	if escaped := (&url.URL{Path: prefix}).EscapedPath(); strings.HasPrefix(path, escaped) {
+		suffix = strings.TrimPrefix(path, escaped)
+	} else if strings.HasPrefix(decoded, prefix) {
+		// the prefix was escaped unusually: escape the
+		// forwarded segments again
+		segments := strings.Split(strings.TrimPrefix(decoded, prefix), "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		suffix = strings.Join(segments, "/")
+	}
+	// otherwise the path is the prefix itself, without the
+	// trailing slash
+	if suffix == "" || strings.HasSuffix(l.URL, "/") {
+		return l.URL + suffix
+	}
+	return l.URL + "/" + suffix
+}
+
+// matchTemplate returns the placeholder values of path, or nil
+// if path does not match the link's template.
+func (l *Link) matchTemplate(path string) map[string]string {
+	want := strings.Split(l.Path, "/")
+	got := strings.Split(path, "/")
+	if len(want) != len(got) {
+		return nil
+	}
+	values := map[string]string{}
+	for i, segment := range want {
+		if m := placeholder.FindStringSubmatch(segment); m != nil && m[0] == segment {
+			if got[i] == "" {
+				return nil
+			}
+			values[m[1]] = got[i]
+		} else if segment != got[i] {
+			return nil
+		}
+	}
+	return values
+}
+
+// expand substitutes the placeholders of the link's URL, escaping
+// values for the part of the URL they appear in.
+func (l *Link) expand(values map[string]string) string {
+	query := strings.Index(l.URL, "?")
+	out := &strings.Builder{}
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(l.URL, -1) {
+		out.WriteString(l.URL[last:loc[0]])
+		last = loc[1]
+		value, ok := values[l.URL[loc[2]:loc[3]]]
+		if !ok {
+			out.WriteString(l.URL[loc[0]:loc[1]])
+		} else if query >= 0 && loc[0] > query {
+			out.WriteString(url.QueryEscape(value))
+		} else {
+			out.WriteString(url.PathEscape(value))
+		}
+	}
+	out.WriteString(l.URL[last:])
+	return out.String()
+}
+
+// literalSegments counts the path segments of a template which
+// are not placeholders.
+func (l *Link) literalSegments() int {
+	n := 0
+	for _, segment := range strings.Split(l.Path, "/") {
+		if !placeholder.MatchString(segment) {
+			n++
+		}
+	}
+	return n
+}
+
+// moreSpecific reports whether link a is a better match than b
+// for a path both match: templates beat wildcards, templates with
+// more literal segments beat other templates and longer wildcards
+// beat shorter ones.
+func moreSpecific(a, b *Link) bool {
+	if a.IsTemplate() != b.IsTemplate() {
+		return a.IsTemplate()
+	}
+	if a.IsTemplate() {
+		return a.literalSegments() > b.literalSegments()
+	}
+	return len(a.Path) > len(b.Path)
+}
+
+// wildcardCandidates lists the wildcard paths which could match
+// path, longest first: "/a/b" gives "/a/b/*", "/a/*" and "/*".
+func wildcardCandidates(path string) []string {
+	p := strings.TrimSuffix(path, "/")
+	candidates := []string{}
+	for {
+		candidates = append(candidates, p+"/*")
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return candidates
+		}
+		p = p[:i]
+	}
+}
+
+// templatePrefixes lists the key prefixes under which templates
+// matching path are stored: those sharing its first segment, and
+// those starting with a placeholder.
+func templatePrefixes(path string) []string {
+	first := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return []string{"/" + first + "/", "/{"}
+}
+
+// anyLink accepts every link in lookupPattern.
+func anyLink(*Link) bool { return true }
+
+// lookupPattern resolves path by an exact match, then by the most
+// specific template and finally by the longest wildcard link
+// covering it, passing over the links accept rejects. get fetches
+// links by their exact path, and templates lists the template
+// links which may match path.
+func lookupPattern(path string, accept func(*Link) bool, get func(string) (*Link, error), templates func(string) ([]Link, error)) (*Link, error) {
+	link, err := get(path)
+	if err != nil || (link != nil && accept(link)) {
+		return link, err
+	}
+	candidates, err := templates(path)
+	if err != nil {
+		return nil, err
+	}
+	var best *Link
+	for i := range candidates {
+		candidate := &candidates[i]
+		if !candidate.IsTemplate() || candidate.matchTemplate(path) == nil || !accept(candidate) {
+			continue
+		}
+		if best == nil || moreSpecific(candidate, best) {
+			best = candidate
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	for _, candidate := range wildcardCandidates(path) {
+		if link, err := get(candidate); err != nil || (link != nil && accept(link)) {
+			return link, err
+		}
+	}
+	return nil, nil
+}
+
+// lookupExact returns the link stored at exactly path, ignoring
+// wildcard and template links that merely match it.
+func lookupExact(store Store, path string) (*Link, error) {
+	link, err := store.Lookup(path)
+	if err != nil || link == nil || link.Path != path {
+		return nil, err
+	}
+	return link, nil
+}
@@ -0,0 +1,151 @@
+package urlshort
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinkTarget(t *testing.T) {
+	for _, tc := range []struct {
+		link     Link
+		path     string
+		expected string
+	}{
+		{Link{Path: "/gh", URL: "https://github.com"}, "/gh", "https://github.com"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh/asfaltboy/urlshort", "https://github.com/asfaltboy/urlshort"},
+		{Link{Path: "/gh/*", URL: "https://github.com"}, "/gh/asfaltboy", "https://github.com/asfaltboy"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh", "https://github.com/"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh/", "https://github.com/"},
+		{Link{Path: "/*", URL: "https://example.com/"}, "/a/b", "https://example.com/a/b"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh/a%3Fx=1", "https://github.com/a%3Fx=1"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh/a%23frag", "https://github.com/a%23frag"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh/a%20b/c", "https://github.com/a%20b/c"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/gh/a%2Fb", "https://github.com/a%2Fb"},
+		{Link{Path: "/g h/*", URL: "https://github.com/"}, "/g%20h/a%3F", "https://github.com/a%3F"},
+		{Link{Path: "/gh/*", URL: "https://github.com/"}, "/%67h/a%3F", "https://github.com/a%3F"},
+	} {
+		if actual := tc.link.Target(tc.path); actual != tc.expected {
+			t.Errorf("Target(%q) of %v returned %q want %q", tc.path, tc.link, actual, tc.expected)
+		}
+	}
+}
+
+func TestMapStoreLongestPrefix(t *testing.T) {
+	store := NewMapStore(map[string]string{
+		"/gh/*":           "https://github.com/",
+		"/gh/asfaltboy/*": "https://github.com/asfaltboy/",
+		"/gh/exact":       "https://github.com/exact",
+	})
+	for path, expected := range map[string]string{
+		"/gh/foo":            "/gh/*",
+		"/gh/asfaltboy/repo": "/gh/asfaltboy/*",
+		"/gh/exact":          "/gh/exact",
+		"/gh/exact/sub":      "/gh/*",
+		"/gh":                "/gh/*",
+	} {
+		link, err := store.Lookup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link == nil || link.Path != expected {
+			t.Errorf("Lookup(%q) returned %v want path %q", path, link, expected)
+		}
+	}
+	if link, _ := store.Lookup("/other"); link != nil {
+		t.Errorf("Lookup returned unexpected link %v", link)
+	}
+}
+
+func TestMultiStoreLongestPrefixAcrossSources(t *testing.T) {
+	yaml, err := NewYAMLStore([]byte(`
+- path: /gh/asfaltboy/*
+  url: https://github.com/asfaltboy/
+- path: /docs
+  url: https://yaml.example.com/docs`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt.Put(Link{Path: "/gh/*", URL: "https://github.com/"})
+	bolt.Put(Link{Path: "/docs/*", URL: "https://bolt.example.com/docs/"})
+	store := NewMultiStore(Source{"bolt", bolt}, Source{"yaml", yaml})
+	handler := Handler(store, getDefaultMux())
+
+	for path, expected := range map[string]string{
+		"/gh/asfaltboy/urlshort": "https://github.com/asfaltboy/urlshort",
+		"/gh/gophercises":        "https://github.com/gophercises",
+		"/docs":                  "https://yaml.example.com/docs",
+		"/docs/intro":            "https://bolt.example.com/docs/intro",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if location := rr.Result().Header.Get("Location"); location != expected {
+			t.Errorf("Handler returned wrong location for %s: got %v want %v", path, location, expected)
+		}
+	}
+
+	if _, source, _ := store.LookupSource("/gh/asfaltboy/x"); source != "yaml" {
+		t.Errorf("LookupSource returned wrong source: got %q want %q", source, "yaml")
+	}
+}
+
+func TestTemplateTarget(t *testing.T) {
+	issue := Link{Path: "/issue/{id}", URL: "https://tracker.example.com/browse/PROJ-{id}"}
+	pr := Link{Path: "/pr/{repo}/{num}", URL: "https://github.com/asfaltboy/{repo}/pull/{num}"}
+	search := Link{Path: "/s/{q}", URL: "https://duckduckgo.com/?q={q}"}
+	for _, tc := range []struct {
+		link     Link
+		path     string
+		expected string
+	}{
+		{issue, "/issue/42", "https://tracker.example.com/browse/PROJ-42"},
+		{pr, "/pr/urlshort/7", "https://github.com/asfaltboy/urlshort/pull/7"},
+		{issue, "/issue/a b", "https://tracker.example.com/browse/PROJ-a%20b"},
+		{search, "/s/a&b c", "https://duckduckgo.com/?q=a%26b+c"},
+	} {
+		if actual := tc.link.Target(tc.path); actual != tc.expected {
+			t.Errorf("Target(%q) of %v returned %q want %q", tc.path, tc.link, actual, tc.expected)
+		}
+	}
+}
+
+func TestTemplateLookup(t *testing.T) {
+	urls := map[string]string{
+		"/issue/{id}":      "https://tracker.example.com/browse/PROJ-{id}",
+		"/issue/new":       "https://tracker.example.com/create",
+		"/issue/*":         "https://tracker.example.com/",
+		"/pr/{repo}/{num}": "https://github.com/asfaltboy/{repo}/pull/{num}",
+		"/pr/urlshort/{n}": "https://github.com/asfaltboy/urlshort/pull/{n}",
+	}
+	bolt, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, url := range urls {
+		bolt.Put(Link{Path: path, URL: url})
+	}
+
+	for _, store := range []Store{NewMapStore(urls), bolt} {
+		for path, expected := range map[string]string{
+			"/issue/42":       "/issue/{id}",
+			"/issue/new":      "/issue/new",
+			"/issue/42/extra": "/issue/*",
+			"/pr/other/1":     "/pr/{repo}/{num}",
+			"/pr/urlshort/1":  "/pr/urlshort/{n}",
+		} {
+			link, err := store.Lookup(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link == nil || link.Path != expected {
+				t.Errorf("Lookup(%q) on %T returned %v want path %q", path, store, link, expected)
+			}
+		}
+		if link, _ := store.Lookup("/pr/x"); link != nil {
+			t.Errorf("Lookup on %T returned unexpected link %v", store, link)
+		}
+	}
+}
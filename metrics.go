@@ -0,0 +1,184 @@
+package urlshort
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsPath is the path the server exposes Metrics at.
+const MetricsPath = "/metrics"
+
+// lookupBuckets are the upper bounds, in seconds, of the lookup
+// latency histogram.
+var lookupBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// Metrics collects the server's metrics and serves them in the
+// Prometheus text format. The Observe methods are safe to call on
+// a nil *Metrics, which records nothing.
+type Metrics struct {
+	mu        sync.Mutex
+	redirects map[string]uint64
+	fallbacks uint64
+	notFound  uint64
+	reloads   map[[2]string]uint64
+	// lookup latency histogram
+	lookupCounts []uint64
+	lookupSum    float64
+	lookupTotal  uint64
+
+	sources []Source
+}
+
+// NewMetrics returns a Metrics reporting the number of links in
+// each of the sources.
+func NewMetrics(sources ...Source) *Metrics {
+	return &Metrics{
+		redirects:    map[string]uint64{},
+		reloads:      map[[2]string]uint64{},
+		lookupCounts: make([]uint64, len(lookupBuckets)),
+		sources:      sources,
+	}
+}
+
+// ObserveRedirect counts a redirect to a link of source.
+func (m *Metrics) ObserveRedirect(source string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.redirects[source]++
+	m.mu.Unlock()
+}
+
+// ObserveFallback counts a request passed to the fallback handler.
+func (m *Metrics) ObserveFallback() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.fallbacks++
+	m.mu.Unlock()
+}
+
+// ObserveNotFound counts a request answered with 404 Not Found.
+func (m *Metrics) ObserveNotFound() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.notFound++
+	m.mu.Unlock()
+}
+
+// ObserveLookup records the latency of a link lookup.
+func (m *Metrics) ObserveLookup(d time.Duration) {
+	if m == nil {
+		return
+	}
+	seconds := d.Seconds()
+	m.mu.Lock()
+	for i, bound := range lookupBuckets {
+		if seconds <= bound {
+			m.lookupCounts[i]++
+		}
+	}
+	m.lookupSum += seconds
+	m.lookupTotal++
+	m.mu.Unlock()
+}
+
+// ObserveReload counts a reload attempt of source, successful if
+// err is nil.
+func (m *Metrics) ObserveReload(source string, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.mu.Lock()
+	m.reloads[[2]string{source, result}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	// count links outside the lock, as it may hit the DB
+	links := map[string]int{}
+	for _, s := range m.sources {
+		if l, err := s.Store.List(); err == nil {
+			links[s.Name] = len(l)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &strings.Builder{}
+
+	b.WriteString("# HELP urlshort_redirects_total Requests redirected, by source of the link.\n")
+	b.WriteString("# TYPE urlshort_redirects_total counter\n")
+	for _, source := range sortedKeys(m.redirects) {
+		fmt.Fprintf(b, "urlshort_redirects_total{source=%q} %d\n", source, m.redirects[source])
+	}
+
+	b.WriteString("# HELP urlshort_fallbacks_total Requests matching no link, passed to the fallback handler.\n")
+	b.WriteString("# TYPE urlshort_fallbacks_total counter\n")
+	fmt.Fprintf(b, "urlshort_fallbacks_total %d\n", m.fallbacks)
+
+	b.WriteString("# HELP urlshort_not_found_total Requests answered with 404 Not Found.\n")
+	b.WriteString("# TYPE urlshort_not_found_total counter\n")
+	fmt.Fprintf(b, "urlshort_not_found_total %d\n", m.notFound)
+
+	b.WriteString("# HELP urlshort_lookup_duration_seconds Latency of link lookups.\n")
+	b.WriteString("# TYPE urlshort_lookup_duration_seconds histogram\n")
+	for i, bound := range lookupBuckets {
+		fmt.Fprintf(b, "urlshort_lookup_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.lookupCounts[i])
+	}
+	fmt.Fprintf(b, "urlshort_lookup_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.lookupTotal)
+	fmt.Fprintf(b, "urlshort_lookup_duration_seconds_sum %g\n", m.lookupSum)
+	fmt.Fprintf(b, "urlshort_lookup_duration_seconds_count %d\n", m.lookupTotal)
+
+	b.WriteString("# HELP urlshort_links Links loaded, by source.\n")
+	b.WriteString("# TYPE urlshort_links gauge\n")
+	for _, s := range m.sources {
+		if n, ok := links[s.Name]; ok {
+			fmt.Fprintf(b, "urlshort_links{source=%q} %d\n", s.Name, n)
+		}
+	}
+
+	b.WriteString("# HELP urlshort_reloads_total Reloads of config files, by source and result.\n")
+	b.WriteString("# TYPE urlshort_reloads_total counter\n")
+	reloads := make([][2]string, 0, len(m.reloads))
+	for key := range m.reloads {
+		reloads = append(reloads, key)
+	}
+	sort.Slice(reloads, func(i, j int) bool {
+		return reloads[i][0] < reloads[j][0] || reloads[i][0] == reloads[j][0] && reloads[i][1] < reloads[j][1]
+	})
+	for _, key := range reloads {
+		fmt.Fprintf(b, "urlshort_reloads_total{source=%q,result=%q} %d\n", key[0], key[1], m.reloads[key])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,54 @@
+package urlshort
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	yaml := NewMapStore(map[string]string{"/a": "https://a.example.com", "/b": "https://b.example.com"})
+	bolt := NewMapStore(map[string]string{"/c": "https://c.example.com"})
+	sources := []Source{{"bolt", bolt}, {"yaml", yaml}}
+	metrics := NewMetrics(sources...)
+	handler := &RedirectHandler{Store: NewMultiStore(sources...), Fallback: getDefaultMux(), Metrics: metrics}
+
+	for _, path := range []string{"/a", "/b", "/c", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	metrics.ObserveNotFound()
+	metrics.ObserveReload("yaml", nil)
+	metrics.ObserveReload("yaml", errors.New("invalid"))
+	metrics.ObserveLookup(2 * time.Second)
+
+	rr := httptest.NewRecorder()
+	metrics.ServeHTTP(rr, httptest.NewRequest("GET", MetricsPath, nil))
+	body := rr.Body.String()
+	for _, expected := range []string{
+		`urlshort_redirects_total{source="bolt"} 1`,
+		`urlshort_redirects_total{source="yaml"} 2`,
+		`urlshort_fallbacks_total 1`,
+		`urlshort_not_found_total 1`,
+		`urlshort_lookup_duration_seconds_bucket{le="1"} 4`,
+		`urlshort_lookup_duration_seconds_count 5`,
+		`urlshort_links{source="bolt"} 1`,
+		`urlshort_links{source="yaml"} 2`,
+		`urlshort_reloads_total{source="yaml",result="failure"} 1`,
+		`urlshort_reloads_total{source="yaml",result="success"} 1`,
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Errorf("Metrics missing %q in:\n%s", expected, body)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var metrics *Metrics
+	metrics.ObserveRedirect("yaml")
+	metrics.ObserveFallback()
+	metrics.ObserveNotFound()
+	metrics.ObserveLookup(time.Second)
+	metrics.ObserveReload("yaml", nil)
+}
@@ -0,0 +1,149 @@
+package urlshort
+
+import (
+	"sort"
+	"time"
+)
+
+// Source is a named Store, such as "yaml" or "bolt".
+type Source struct {
+	Name  string
+	Store Store
+}
+
+// MultiStore combines several sources into a single read-only
+// Store. A path stored exactly in any source wins over template
+// and wildcard links, with earlier sources taking precedence;
+// otherwise the most specific template, or failing that the
+// longest wildcard link, across all sources is used.
+type MultiStore struct {
+	sources []Source
+}
+
+// NewMultiStore returns a MultiStore over sources, in order of
+// precedence.
+func NewMultiStore(sources ...Source) *MultiStore {
+	return &MultiStore{sources}
+}
+
+// Sources returns the combined sources in order of precedence.
+func (m *MultiStore) Sources() []Source {
+	return m.sources
+}
+
+// LookupSource is like Lookup but also returns the name of the
+// source the link was found in, which is also set as the link's
+// Source.
+func (m *MultiStore) LookupSource(path string) (*Link, string, error) {
+	return m.lookupSource(path, func(store Store) (*Link, error) {
+		return store.Lookup(path)
+	})
+}
+
+// LookupActive is like Lookup but passes over links inactive at
+// now, see Link.Active, so that they do not hide the links of
+// later sources.
+func (m *MultiStore) LookupActive(path string, now time.Time) (*Link, error) {
+	link, _, err := m.lookupSource(path, func(store Store) (*Link, error) {
+		return lookupActive(store, path, now)
+	})
+	return link, err
+}
+
+func (m *MultiStore) lookupSource(path string, lookup func(Store) (*Link, error)) (*Link, string, error) {
+	var best *Link
+	var bestSource string
+	for _, s := range m.sources {
+		link, err := lookup(s.Store)
+		if err != nil {
+			return nil, "", err
+		}
+		if link == nil {
+			continue
+		}
+		found := *link
+		found.Source = s.Name
+		if link.Path == path {
+			return &found, s.Name, nil
+		}
+		if best == nil || moreSpecific(&found, best) {
+			best, bestSource = &found, s.Name
+		}
+	}
+	return best, bestSource, nil
+}
+
+// Conflict is a path defined in more than one source.
+type Conflict struct {
+	Path string
+	// Sources defining the path, in order of precedence.
+	Sources []string
+}
+
+// Winner returns the name of the source whose link is served.
+func (c Conflict) Winner() string {
+	return c.Sources[0]
+}
+
+// Conflicts lists the paths defined in more than one source,
+// ordered by path.
+func (m *MultiStore) Conflicts() ([]Conflict, error) {
+	defined := map[string][]string{}
+	for _, s := range m.sources {
+		links, err := s.Store.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			defined[link.Path] = append(defined[link.Path], s.Name)
+		}
+	}
+	conflicts := []Conflict{}
+	for path, sources := range defined {
+		if len(sources) > 1 {
+			conflicts = append(conflicts, Conflict{path, sources})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return conflicts, nil
+}
+
+// Lookup implements Store.
+func (m *MultiStore) Lookup(path string) (*Link, error) {
+	link, _, err := m.LookupSource(path)
+	return link, err
+}
+
+// Put implements Store, always returning ErrReadOnly; write to
+// the individual sources instead.
+func (m *MultiStore) Put(link Link) error {
+	return ErrReadOnly
+}
+
+// Delete implements Store, always returning ErrReadOnly.
+func (m *MultiStore) Delete(path string) error {
+	return ErrReadOnly
+}
+
+// List implements Store, listing the links that are served: a
+// path defined in several sources is listed once, from the
+// source taking precedence.
+func (m *MultiStore) List() ([]Link, error) {
+	seen := map[string]bool{}
+	links := []Link{}
+	for _, s := range m.sources {
+		sourceLinks, err := s.Store.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range sourceLinks {
+			if !seen[link.Path] {
+				seen[link.Path] = true
+				link.Source = s.Name
+				links = append(links, link)
+			}
+		}
+	}
+	sortLinks(links)
+	return links, nil
+}
@@ -0,0 +1,97 @@
+package urlshort
+
+// Role is a bundle of scopes: viewers read links and stats,
+// editors also create links and change those they own or edit,
+// and admins may do anything.
+type Role string
+
+// Roles of principals.
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// Scopes returns the scopes granted by the role, or nil for an
+// unknown role.
+func (r Role) Scopes() []Scope {
+	switch r {
+	case RoleViewer:
+		return []Scope{ScopeReadLinks, ScopeReadStats}
+	case RoleEditor:
+		return []Scope{ScopeReadLinks, ScopeWriteLinks, ScopeReadStats}
+	case RoleAdmin:
+		return []Scope{ScopeAdmin}
+	}
+	return nil
+}
+
+// Role returns the highest role the principal's scopes amount to,
+// or "" if it can not even read links.
+func (p *Principal) Role() Role {
+	switch {
+	case p.HasScope(ScopeAdmin):
+		return RoleAdmin
+	case p.HasScope(ScopeWriteLinks):
+		return RoleEditor
+	case p.HasScope(ScopeReadLinks):
+		return RoleViewer
+	}
+	return ""
+}
+
+// CanEdit reports whether p may change or delete link: admins may
+// change any link, editors links they own, links shared with one
+// of their groups through Editors, and links without an owner. A
+// nil principal, as when authentication is disabled, may change
+// any link.
+func (p *Principal) CanEdit(link *Link) bool {
+	if p == nil || p.Role() == RoleAdmin {
+		return true
+	}
+	if p.Role() != RoleEditor {
+		return false
+	}
+	if link.Owner == "" || link.Owner == p.Subject {
+		return true
+	}
+	for _, editors := range link.Editors {
+		for _, group := range p.Groups {
+			if editors == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanShare reports whether p may change the owner and editors of
+// link: only its owner and admins may.
+func (p *Principal) CanShare(link *Link) bool {
+	return p == nil || p.Role() == RoleAdmin || (p.Role() == RoleEditor && (link.Owner == "" || link.Owner == p.Subject))
+}
+
+// applyOwnership sets the owner and editors of link, about to be
+// written by p, from the existing link if p may not change them or
+// leaves them out. New links are owned by their creator.
+func applyOwnership(p *Principal, link *Link, existing *Link) {
+	if existing == nil {
+		if p != nil && (link.Owner == "" || p.Role() != RoleAdmin) {
+			link.Owner = p.Subject
+		}
+		return
+	}
+	if link.Owner == "" {
+		link.Owner = existing.Owner
+	}
+	if link.Editors == nil {
+		// an empty list, rather than none, removes the editors
+		link.Editors = existing.Editors
+	}
+	if !p.CanShare(existing) {
+		link.Owner, link.Editors = existing.Owner, existing.Editors
+	} else if p != nil && p.Role() != RoleAdmin {
+		// owners may share a link, not give it away
+		link.Owner = existing.Owner
+	}
+}
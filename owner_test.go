@@ -0,0 +1,165 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testPrincipals authenticates bearer tokens naming its principals.
+type testPrincipals map[string]*Principal
+
+func (t testPrincipals) Authenticate(r *http.Request) (*Principal, error) {
+	return t[bearerToken(r)], nil
+}
+
+func TestCanEdit(t *testing.T) {
+	ann := &Principal{Subject: "user:ann", Scopes: RoleEditor.Scopes(), Groups: []string{"eng"}}
+	bob := &Principal{Subject: "user:bob", Scopes: RoleEditor.Scopes()}
+	viewer := &Principal{Subject: "user:vic", Scopes: RoleViewer.Scopes(), Groups: []string{"eng"}}
+	admin := &Principal{Subject: "key:1", Scopes: RoleAdmin.Scopes()}
+
+	link := &Link{Path: "/docs", Owner: "user:bob", Editors: []string{"eng"}}
+	for _, tc := range []struct {
+		p        *Principal
+		edit     bool
+		share    bool
+		expected Role
+	}{
+		{ann, true, false, RoleEditor},
+		{bob, true, true, RoleEditor},
+		{viewer, false, false, RoleViewer},
+		{admin, true, true, RoleAdmin},
+	} {
+		if tc.p.Role() != tc.expected || tc.p.CanEdit(link) != tc.edit || tc.p.CanShare(link) != tc.share {
+			t.Errorf("%s: got role %s, edit %v, share %v", tc.p.Subject, tc.p.Role(), tc.p.CanEdit(link), tc.p.CanShare(link))
+		}
+	}
+	if (*Principal)(nil).CanEdit(link) != true || ann.CanEdit(&Link{Owner: "user:bob"}) {
+		t.Errorf("Expected anyone to edit without authentication, and only owners otherwise")
+	}
+}
+
+func TestAPIOwnership(t *testing.T) {
+	store, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewMapStore(map[string]string{"/wiki": "https://wiki.example.com"})
+	auth := testPrincipals{
+		"ann":   {Subject: "user:ann", Scopes: RoleEditor.Scopes(), Groups: []string{"eng"}},
+		"bob":   {Subject: "user:bob", Scopes: RoleEditor.Scopes()},
+		"eve":   {Subject: "user:eve", Scopes: RoleEditor.Scopes()},
+		"admin": {Subject: "user:root", Scopes: RoleAdmin.Scopes()},
+	}
+	api := Protect(auth, ScopeReadLinks, ScopeWriteLinks, APIHandler(store, nil, Source{"yaml", config}))
+	do := func(token, method, target, body string) *http.Response {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	res := do("bob", "POST", "/api/links", `{"path": "/docs", "url": "https://docs.example.com", "owner": "user:ann"}`)
+	var link Link
+	json.NewDecoder(res.Body).Decode(&link)
+	if res.StatusCode != http.StatusCreated || link.Owner != "user:bob" {
+		t.Fatalf("Expected the link owned by its creator, got %d %+v", res.StatusCode, link)
+	}
+	if res := do("ann", "PUT", "/api/links/docs", `{"url": "https://ann.example.com"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected others forbidden to change the link, got %d", res.StatusCode)
+	}
+	if res := do("ann", "DELETE", "/api/links/docs", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected others forbidden to delete the link, got %d", res.StatusCode)
+	}
+	res = do("bob", "PUT", "/api/links/docs", `{"url": "https://docs.example.com", "owner": "user:ann", "editors": ["eng"]}`)
+	link = Link{}
+	json.NewDecoder(res.Body).Decode(&link)
+	if res.StatusCode != http.StatusOK || link.Owner != "user:bob" || len(link.Editors) != 1 {
+		t.Errorf("Expected the owner to share, not give away, the link, got %d %+v", res.StatusCode, link)
+	}
+	res = do("ann", "PUT", "/api/links/docs", `{"url": "https://ann.example.com", "editors": []}`)
+	link = Link{}
+	json.NewDecoder(res.Body).Decode(&link)
+	if res.StatusCode != http.StatusOK || link.URL != "https://ann.example.com" || len(link.Editors) != 1 {
+		t.Errorf("Expected editors to change the link but not its editors, got %d %+v", res.StatusCode, link)
+	}
+	if res := do("admin", "PUT", "/api/links/docs", `{"url": "https://docs.example.com", "owner": "user:ann"}`); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected admins to change any link, got %d", res.StatusCode)
+	}
+	if link, _ := store.Lookup("/docs"); link == nil || link.Owner != "user:ann" {
+		t.Errorf("Expected the owner stored, got %+v", link)
+	}
+	do("bob", "POST", "/api/links", `{"path": "/blog", "url": "https://blog.example.com", "editors": ["eng"]}`)
+	if res := do("admin", "PUT", "/api/links/blog", `{"url": "https://new-blog.example.com"}`); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected admins to change any link, got %d", res.StatusCode)
+	}
+	if link, _ := store.Lookup("/blog"); link == nil || link.Owner != "user:bob" || len(link.Editors) != 1 {
+		t.Errorf("Expected the owner and editors kept when left out, got %+v", link)
+	}
+	if res := do("eve", "PUT", "/api/links/blog", `{"url": "https://eve.example.com"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the link still closed to other editors, got %d", res.StatusCode)
+	}
+
+	res = do("ann", "GET", "/api/links/wiki", "")
+	link = Link{}
+	json.NewDecoder(res.Body).Decode(&link)
+	if res.StatusCode != http.StatusOK || !link.ConfigManaged {
+		t.Errorf("Expected the config link reported config-managed, got %d %+v", res.StatusCode, link)
+	}
+	if res := do("admin", "DELETE", "/api/links/wiki", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected config-managed links read-only, got %d", res.StatusCode)
+	}
+	if res := do("admin", "POST", "/api/links", `{"path": "/wiki", "url": "https://example.com"}`); res.StatusCode != http.StatusConflict {
+		t.Errorf("Expected config-managed paths not to be shadowed, got %d", res.StatusCode)
+	}
+}
+
+func TestUIOwnership(t *testing.T) {
+	bolt := NewMapStore(map[string]string{})
+	bolt.Put(Link{Path: "/docs", URL: "https://docs.example.com", Owner: "user:bob"})
+	bolt.Put(Link{Path: "/team", URL: "https://team.example.com", Owner: "user:bob", Editors: []string{"eng"}})
+	yaml := NewMapStore(map[string]string{"/wiki": "https://wiki.example.com"})
+	auth := testPrincipals{"ann": {Subject: "user:ann", Scopes: RoleEditor.Scopes(), Groups: []string{"eng"}}}
+	ui := Protect(auth, ScopeReadLinks, ScopeWriteLinks, UIHandler(NewMultiStore(Source{"bolt", bolt}, Source{"yaml", yaml}), "bolt", nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer ann")
+		ui.ServeHTTP(w, r)
+	})
+
+	body := doUIRequest(handler, "GET", UIPrefix, "", nil).Body.String()
+	if strings.Count(body, "read-only") != 1 || strings.Count(body, "config-managed") != 1 ||
+		!strings.Contains(body, `href="/ui/edit?path=%2fteam"`) {
+		t.Errorf("Expected only the shared link editable:\n%s", body)
+	}
+
+	token := strings.Repeat("a", 64)
+	form := url.Values{"csrf_token": {token}, "path": {"/docs"}}
+	if rr := doUIRequest(handler, "POST", UIPrefix+"/delete", token, form); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 deleting another's link, got %d", rr.Code)
+	}
+	form = url.Values{"csrf_token": {token}, "path": {"/wiki"}, "url": {"https://mine.example.com"}}
+	if rr := doUIRequest(handler, "POST", UIPrefix+"/new", token, form); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 shadowing a config-managed link, got %d", rr.Code)
+	}
+	form = url.Values{"csrf_token": {token}, "path": {"/mine"}, "url": {"https://mine.example.com"}}
+	doUIRequest(handler, "POST", UIPrefix+"/new", token, form)
+	if link, _ := bolt.Lookup("/mine"); link == nil || link.Owner != "user:ann" {
+		t.Errorf("Expected the link owned by its creator, got %+v", link)
+	}
+}
+
+func TestParseRolesNames(t *testing.T) {
+	roles, err := ParseRoles("*=viewer,eng=editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Principal{Scopes: append(roles["*"], roles["eng"]...)}
+	if p.Role() != RoleEditor || len(roles["*"]) != 2 {
+		t.Errorf("Expected roles expanded to their scopes, got %v", roles)
+	}
+}
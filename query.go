@@ -0,0 +1,69 @@
+package urlshort
+
+import "net/url"
+
+// QueryPolicy decides what happens to the query string of a
+// request when redirecting it.
+type QueryPolicy string
+
+const (
+	// QueryDrop ignores the request's query, redirecting to the
+	// link's URL as is.
+	QueryDrop QueryPolicy = "drop"
+	// QueryAppend adds the request's query to the URL's own,
+	// keeping both values of parameters set in each.
+	QueryAppend QueryPolicy = "append"
+	// QueryMerge merges the request's query into the URL's own,
+	// the request's values replacing those of the URL.
+	QueryMerge QueryPolicy = "merge"
+	// QueryTargetWins merges the request's query into the URL's
+	// own, keeping the URL's values of parameters set in both.
+	QueryTargetWins QueryPolicy = "target-wins"
+)
+
+// Valid reports whether p is one of the known policies.
+func (p QueryPolicy) Valid() bool {
+	switch p {
+	case QueryDrop, QueryAppend, QueryMerge, QueryTargetWins:
+		return true
+	}
+	return false
+}
+
+// applyQuery forwards the raw query of a request to target
+// according to policy. Targets which can not be parsed are
+// returned unchanged.
+func applyQuery(target, rawQuery string, policy QueryPolicy) string {
+	if rawQuery == "" || policy == "" || policy == QueryDrop {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	if u.RawQuery == "" || policy == QueryAppend {
+		if u.RawQuery == "" {
+			u.RawQuery = rawQuery
+		} else {
+			u.RawQuery += "&" + rawQuery
+		}
+		return u.String()
+	}
+
+	own, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return target
+	}
+	incoming, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return target
+	}
+	for key, values := range incoming {
+		if _, ok := own[key]; ok && policy == QueryTargetWins {
+			continue
+		}
+		own[key] = values
+	}
+	u.RawQuery = own.Encode()
+	return u.String()
+}
@@ -0,0 +1,45 @@
+package urlshort
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplyQuery(t *testing.T) {
+	for _, tc := range []struct {
+		target   string
+		query    string
+		policy   QueryPolicy
+		expected string
+	}{
+		{"https://github.com/x", "tab=readme", QueryDrop, "https://github.com/x"},
+		{"https://github.com/x", "tab=readme", "", "https://github.com/x"},
+		{"https://github.com/x", "tab=readme", QueryAppend, "https://github.com/x?tab=readme"},
+		{"https://github.com/x?tab=code", "tab=readme", QueryAppend, "https://github.com/x?tab=code&tab=readme"},
+		{"https://github.com/x?tab=code&a=1", "tab=readme&b=2", QueryMerge, "https://github.com/x?a=1&b=2&tab=readme"},
+		{"https://github.com/x?tab=code&a=1", "tab=readme&b=2", QueryTargetWins, "https://github.com/x?a=1&b=2&tab=code"},
+		{"https://github.com/x#top", "tab=readme", QueryMerge, "https://github.com/x?tab=readme#top"},
+	} {
+		if actual := applyQuery(tc.target, tc.query, tc.policy); actual != tc.expected {
+			t.Errorf("applyQuery(%q, %q, %q) returned %q want %q", tc.target, tc.query, tc.policy, actual, tc.expected)
+		}
+	}
+}
+
+func TestHandlerQueryPolicy(t *testing.T) {
+	store := NewMapStore(nil)
+	store.Put(Link{Path: "/urlshort", URL: "https://github.com/asfaltboy/urlshort"})
+	store.Put(Link{Path: "/search", URL: "https://duckduckgo.com/?ia=web", Query: QueryTargetWins})
+	handler := &RedirectHandler{Store: store, DefaultQuery: QueryAppend}
+
+	for path, expected := range map[string]string{
+		"/urlshort?tab=readme":   "https://github.com/asfaltboy/urlshort?tab=readme",
+		"/search?q=go&ia=images": "https://duckduckgo.com/?ia=web&q=go",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if location := rr.Result().Header.Get("Location"); location != expected {
+			t.Errorf("Handler returned wrong location for %s: got %v want %v", path, location, expected)
+		}
+	}
+}
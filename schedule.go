@@ -0,0 +1,138 @@
+package urlshort
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ScheduledTarget is a URL a link redirects to from a given time
+// on, until the next scheduled target.
+type ScheduledTarget struct {
+	From time.Time `json:"from"`
+	URL  string    `json:"url"`
+}
+
+// localLayouts are the time formats accepted besides RFC 3339,
+// read in the time zone of the link.
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Active reports whether the link is within its activation window
+// at now. Inactive links are served as if they did not exist.
+func (l *Link) Active(now time.Time) bool {
+	if l.ActiveFrom != nil && now.Before(*l.ActiveFrom) {
+		return false
+	}
+	return l.ActiveUntil == nil || now.Before(*l.ActiveUntil)
+}
+
+// activeAt accepts the links active at now in lookupPattern.
+func activeAt(now time.Time) func(*Link) bool {
+	return func(l *Link) bool { return l.Active(now) }
+}
+
+// activeStore is implemented by stores which can pass over
+// inactive links when looking up a path.
+type activeStore interface {
+	LookupActive(path string, now time.Time) (*Link, error)
+}
+
+// lookupActive looks up path in store, passing over the links
+// inactive at now, so that they do not hide the wildcard and
+// template links, or the links of later sources, they would win
+// over. Stores unable to do so answer as if they had no link.
+func lookupActive(store Store, path string, now time.Time) (*Link, error) {
+	if s, ok := store.(activeStore); ok {
+		return s.LookupActive(path, now)
+	}
+	link, err := store.Lookup(path)
+	if link != nil && !link.Active(now) {
+		return nil, err
+	}
+	return link, err
+}
+
+// At returns a copy of the link with its URL set to the target
+// scheduled at now, if any.
+func (l *Link) At(now time.Time) *Link {
+	at := *l
+	for _, s := range l.Schedule {
+		if now.Before(s.From) {
+			break
+		}
+		at.URL = s.URL
+	}
+	return &at
+}
+
+type scheduleEntry struct {
+	From string `yaml:"from" json:"from"`
+	URL  string `yaml:"url" json:"url"`
+}
+
+// scheduleConfig is the activation window and schedule of a link
+// as written in config files and API requests. Times are in RFC
+// 3339 or, without an offset, such as "2024-03-01 09:00", local to
+// TimeZone, an IANA name such as "Europe/Berlin" defaulting to UTC.
+type scheduleConfig struct {
+	ActiveFrom  string          `yaml:"active_from" json:"active_from"`
+	ActiveUntil string          `yaml:"active_until" json:"active_until"`
+	TimeZone    string          `yaml:"timezone" json:"timezone"`
+	Schedule    []scheduleEntry `yaml:"schedule" json:"schedule"`
+}
+
+// apply sets the activation window and schedule of link, the
+// schedule ordered by time.
+func (c scheduleConfig) apply(link *Link) error {
+	loc := time.UTC
+	if c.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(c.TimeZone); err != nil {
+			return fmt.Errorf("invalid timezone %q", c.TimeZone)
+		}
+	}
+	var err error
+	if link.ActiveFrom, err = parseScheduleTime("active_from", c.ActiveFrom, loc); err != nil {
+		return err
+	}
+	if link.ActiveUntil, err = parseScheduleTime("active_until", c.ActiveUntil, loc); err != nil {
+		return err
+	}
+	if link.ActiveFrom != nil && link.ActiveUntil != nil && !link.ActiveFrom.Before(*link.ActiveUntil) {
+		return fmt.Errorf("active_from must be before active_until")
+	}
+	link.Schedule = nil
+	for _, e := range c.Schedule {
+		from, err := parseScheduleTime("schedule from", e.From, loc)
+		if err != nil {
+			return err
+		}
+		if from == nil || e.URL == "" {
+			return fmt.Errorf("scheduled targets need both from and url")
+		}
+		link.Schedule = append(link.Schedule, ScheduledTarget{*from, e.URL})
+	}
+	sort.SliceStable(link.Schedule, func(i, j int) bool { return link.Schedule[i].From.Before(link.Schedule[j].From) })
+	return nil
+}
+
+func parseScheduleTime(name, value string, loc *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s time %q, expected RFC 3339 or a local time such as 2006-01-02 15:04", name, value)
+}
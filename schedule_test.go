@@ -0,0 +1,149 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScheduleConfig(t *testing.T) {
+	yml := []byte(`
+- path: /launch
+  url: https://teaser.example.com
+  active_from: 2030-01-01 09:00
+  active_until: 2030-02-01T00:00:00Z
+  timezone: Europe/Berlin
+  schedule:
+    - from: 2030-01-15
+      url: https://sale.example.com
+    - from: 2030-01-10 12:00
+      url: https://launch.example.com
+`)
+	store, err := NewYAMLStore(yml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := store.Lookup("/launch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC); link.ActiveFrom == nil || !link.ActiveFrom.Equal(want) {
+		t.Errorf("Expected active_from %v, got %v", want, link.ActiveFrom)
+	}
+	if len(link.Schedule) != 2 || link.Schedule[0].URL != "https://launch.example.com" {
+		t.Fatalf("Expected the schedule ordered by time, got %v", link.Schedule)
+	}
+
+	for _, c := range []struct {
+		now    time.Time
+		active bool
+		url    string
+	}{
+		{time.Date(2030, 1, 1, 7, 59, 0, 0, time.UTC), false, ""},
+		{time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC), true, "https://teaser.example.com"},
+		{time.Date(2030, 1, 10, 11, 0, 0, 0, time.UTC), true, "https://launch.example.com"},
+		{time.Date(2030, 1, 20, 0, 0, 0, 0, time.UTC), true, "https://sale.example.com"},
+		{time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC), false, ""},
+	} {
+		if link.Active(c.now) != c.active {
+			t.Errorf("At %v expected active %v", c.now, c.active)
+		}
+		if c.active && link.At(c.now).URL != c.url {
+			t.Errorf("At %v expected %s, got %s", c.now, c.url, link.At(c.now).URL)
+		}
+	}
+}
+
+func TestScheduleConfigErrors(t *testing.T) {
+	for _, yml := range []string{
+		"- {path: /a, url: 'https://a.example.com', timezone: Nowhere/Special}",
+		"- {path: /a, url: 'https://a.example.com', active_from: tomorrow}",
+		"- {path: /a, url: 'https://a.example.com', active_from: 2030-02-01, active_until: 2030-01-01}",
+		"- {path: /a, url: 'https://a.example.com', schedule: [{url: 'https://b.example.com'}]}",
+	} {
+		if _, err := NewYAMLStore([]byte(yml)); err == nil {
+			t.Errorf("Expected an error for %s", yml)
+		}
+	}
+}
+
+func TestHandlerSchedule(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	store := NewMapStore(nil)
+	store.Put(Link{Path: "/soon", URL: "https://soon.example.com", ActiveFrom: &future})
+	store.Put(Link{Path: "/switched", URL: "https://old.example.com", Schedule: []ScheduledTarget{
+		{past, "https://new.example.com"},
+		{future, "https://later.example.com"},
+	}})
+	handler := &RedirectHandler{Store: store, Fallback: getDefaultMux()}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/soon", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a link not yet active to fall back, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/switched", nil))
+	if loc := rr.Header().Get("Location"); loc != "https://new.example.com" {
+		t.Errorf("Expected the scheduled target, got %q", loc)
+	}
+}
+
+func TestInactiveLinksDoNotHide(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	bolt, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt.Put(Link{Path: "/docs", URL: "https://new-docs.example.com", ActiveFrom: &future})
+	bolt.Put(Link{Path: "/gh/{repo}", URL: "https://gitlab.com/{repo}", ActiveFrom: &future})
+	bolt.Put(Link{Path: "/gh/*", URL: "https://github.com/"})
+	yaml := NewMapStore(map[string]string{"/docs": "https://docs.example.com"})
+	store := NewCachedStore(NewMultiStore(Source{"bolt", bolt}, Source{"yaml", yaml}), time.Hour)
+	handler := &RedirectHandler{Store: store, Fallback: getDefaultMux()}
+
+	for path, expected := range map[string]string{
+		"/docs":    "https://docs.example.com",
+		"/gh/repo": "https://github.com/repo",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if loc := rr.Header().Get("Location"); loc != expected {
+			t.Errorf("%s: expected the link hidden by an inactive one, got %d %q", path, rr.Code, loc)
+		}
+	}
+}
+
+func TestBoltStoreSchedule(t *testing.T) {
+	store, err := NewBoltStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2030, 1, 1, 9, 0, 0, 0, time.FixedZone("CET", 3600))
+	if err := store.Put(Link{Path: "/a", URL: "https://a.example.com", ActiveUntil: &from,
+		Schedule: []ScheduledTarget{{from, "https://b.example.com"}}}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := store.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.ActiveUntil == nil || !link.ActiveUntil.Equal(from) || len(link.Schedule) != 1 || !link.Schedule[0].From.Equal(from) {
+		t.Errorf("Expected the schedule to round-trip, got %+v", link)
+	}
+}
+
+func TestAPISchedule(t *testing.T) {
+	handler := APIHandler(NewMapStore(nil), nil)
+	res := doAPIRequest(handler, "POST", APIPrefix, `{"path": "/a", "url": "https://a.example.com",
+		"timezone": "America/New_York", "schedule": [{"from": "2030-01-01 09:00", "url": "https://b.example.com"}]}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", res.StatusCode)
+	}
+	res = doAPIRequest(handler, "PUT", APIPrefix+"/a", `{"url": "https://a.example.com",
+		"schedule": [{"from": "2030-01-01T09:00:00Z", "url": "ftp://b.example.com"}]}`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid scheduled url, got %d", res.StatusCode)
+	}
+}
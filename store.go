@@ -0,0 +1,310 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Link is a single short path and the URL it redirects to.
+type Link struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+	// Status is the HTTP redirect status code, or 0 to use the
+	// handler's default.
+	Status int `json:"status,omitempty"`
+	// Query is the policy for forwarding the request's query
+	// string, or "" to use the handler's default.
+	Query QueryPolicy `json:"query,omitempty"`
+	// ExpiresAt is when the link stops redirecting, answering
+	// 410 Gone instead, or nil if it never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ActiveFrom and ActiveUntil, if set, bound the window in
+	// which the link exists.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// Schedule lists the URLs the link switches to over time,
+	// ordered by time. URL is used before the first of them.
+	Schedule []ScheduledTarget `json:"schedule,omitempty"`
+	// VCS, if set, makes the link a Go vanity import path of the
+	// repository at URL, see IsGoImport.
+	VCS string `json:"vcs,omitempty"`
+	// Owner is the subject of the principal who created the
+	// link, and Editors the groups it is shared with, see
+	// Principal.CanEdit.
+	Owner   string   `json:"owner,omitempty"`
+	Editors []string `json:"editors,omitempty"`
+	// Source is the name of the source the link was found in, as
+	// set by MultiStore. It is never stored.
+	Source string `json:"source,omitempty"`
+	// ConfigManaged is set by the API for links of config files,
+	// which can only be changed by editing the files. It is
+	// never stored.
+	ConfigManaged bool `json:"config_managed,omitempty"`
+}
+
+// Store is a source of short paths. Implementations must be
+// safe for concurrent use, as Lookup is called on every request.
+type Store interface {
+	// Lookup returns the link stored for path or, failing
+	// that, the longest wildcard link covering path. It returns
+	// nil if there is none.
+	Lookup(path string) (*Link, error)
+	// Put adds the link, replacing any existing link with
+	// the same path.
+	Put(link Link) error
+	// Delete removes the link for path. Deleting a missing
+	// path is not an error.
+	Delete(path string) error
+	// List returns all stored links ordered by path.
+	List() ([]Link, error)
+}
+
+// ErrExists is returned when creating a link at a path already
+// taken by another.
+var ErrExists = errors.New("path already exists")
+
+// creator is implemented by stores able to add a link only if its
+// path is free, checking and writing at once.
+type creator interface {
+	// Create adds the link, or returns ErrExists if a link is
+	// stored at exactly its path.
+	Create(link Link) error
+}
+
+// createLink adds link to store as creator.Create does. Stores
+// that are not creators are checked before the write, which is
+// only safe while nothing else writes to them.
+func createLink(store Store, link Link) error {
+	if c, ok := store.(creator); ok {
+		return c.Create(link)
+	}
+	existing, err := lookupExact(store, link.Path)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrExists
+	}
+	return store.Put(link)
+}
+
+type pathConfig struct {
+	Path      string      `yaml:"path" json:"path"`
+	URL       string      `yaml:"url" json:"url"`
+	Status    int         `yaml:"status" json:"status"`
+	Query     QueryPolicy `yaml:"query" json:"query"`
+	ExpiresAt *time.Time  `yaml:"expires_at" json:"expires_at"`
+	TTL       string      `yaml:"ttl" json:"ttl"`
+	VCS       string      `yaml:"vcs" json:"vcs"`
+
+	scheduleConfig `yaml:",inline"`
+}
+
+// MapStore is an in-memory Store backed by a map of paths to links.
+type MapStore struct {
+	mu    sync.RWMutex
+	links map[string]Link
+}
+
+// NewMapStore returns a MapStore holding links for pathsToUrls.
+func NewMapStore(pathsToUrls map[string]string) *MapStore {
+	m := &MapStore{links: make(map[string]Link, len(pathsToUrls))}
+	for path, url := range pathsToUrls {
+		m.links[path] = Link{Path: path, URL: url}
+	}
+	return m
+}
+
+// DuplicatePathsError is returned when a config defines the same
+// path more than once.
+type DuplicatePathsError struct {
+	// Paths maps each duplicated path to the indexes of the
+	// entries defining it.
+	Paths map[string][]int
+}
+
+func (e *DuplicatePathsError) Error() string {
+	paths := make([]string, 0, len(e.Paths))
+	for path := range e.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	msgs := make([]string, len(paths))
+	for i, path := range paths {
+		msgs[i] = fmt.Sprintf("%s (entries %v)", path, e.Paths[path])
+	}
+	return "duplicate paths: " + strings.Join(msgs, ", ")
+}
+
+// Lenient wraps a config parser such as NewYAMLStore so that
+// duplicate paths are passed to warn instead of failing the
+// parse. The last entry of a duplicated path wins.
+func Lenient(parse func([]byte) (*MapStore, error), warn func(error)) func([]byte) (*MapStore, error) {
+	return func(content []byte) (*MapStore, error) {
+		store, err := parse(content)
+		var dup *DuplicatePathsError
+		if errors.As(err, &dup) && store != nil {
+			warn(err)
+			return store, nil
+		}
+		return store, err
+	}
+}
+
+// Generate a map store from a list of paths as per marshalled config.
+// Duplicate paths are reported as a *DuplicatePathsError, returned
+// along with the store in which the last entry of each path wins.
+// A ttl counts from since.
+func pathConfigToStore(shortPaths []pathConfig, since time.Time) (*MapStore, error) {
+	m := &MapStore{links: make(map[string]Link, len(shortPaths))}
+	seen := map[string]int{}
+	dups := map[string][]int{}
+	for i, v := range shortPaths {
+		if first, ok := seen[v.Path]; ok {
+			if len(dups[v.Path]) == 0 {
+				dups[v.Path] = []int{first}
+			}
+			dups[v.Path] = append(dups[v.Path], i)
+		}
+		seen[v.Path] = i
+		if v.Status != 0 && !ValidRedirectStatus(v.Status) {
+			return nil, fmt.Errorf("path %s: invalid redirect status %d", v.Path, v.Status)
+		}
+		if v.Query != "" && !v.Query.Valid() {
+			return nil, fmt.Errorf("path %s: invalid query policy %q", v.Path, v.Query)
+		}
+		if v.VCS != "" && !ValidVCS(v.VCS) {
+			return nil, fmt.Errorf("path %s: invalid vcs %q", v.Path, v.VCS)
+		}
+		expiresAt, err := expiry(v.ExpiresAt, v.TTL, since)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %v", v.Path, err)
+		}
+		link := Link{Path: v.Path, URL: v.URL, Status: v.Status, Query: v.Query, ExpiresAt: expiresAt, VCS: v.VCS}
+		if err := v.scheduleConfig.apply(&link); err != nil {
+			return nil, fmt.Errorf("path %s: %v", v.Path, err)
+		}
+		m.links[v.Path] = link
+	}
+	if len(dups) > 0 {
+		return m, &DuplicatePathsError{dups}
+	}
+	return m, nil
+}
+
+// NewYAMLStore parses the provided YAML into a MapStore. See
+// YAMLHandler for the expected format. Paths defined more than
+// once are reported as a *DuplicatePathsError, see Lenient.
+func NewYAMLStore(yml []byte) (*MapStore, error) {
+	return NewYAMLStoreAt(yml, time.Now())
+}
+
+// NewYAMLStoreAt is like NewYAMLStore but counts ttls from since,
+// such as the modification time of the file the YAML was read
+// from.
+func NewYAMLStoreAt(yml []byte, since time.Time) (*MapStore, error) {
+	yamlPaths := []pathConfig{}
+	if err := yaml.UnmarshalStrict(yml, &yamlPaths); err != nil {
+		return nil, err
+	}
+	return pathConfigToStore(yamlPaths, since)
+}
+
+// NewJSONStore parses the provided JSON into a MapStore. See
+// JSONHandler for the expected format. Paths defined more than
+// once are reported as a *DuplicatePathsError, see Lenient.
+func NewJSONStore(j []byte) (*MapStore, error) {
+	return NewJSONStoreAt(j, time.Now())
+}
+
+// NewJSONStoreAt is like NewJSONStore but counts ttls from since,
+// see NewYAMLStoreAt.
+func NewJSONStoreAt(j []byte, since time.Time) (*MapStore, error) {
+	jsonPaths := []pathConfig{}
+	if err := json.Unmarshal(j, &jsonPaths); err != nil {
+		return nil, err
+	}
+	return pathConfigToStore(jsonPaths, since)
+}
+
+// Lookup implements Store.
+func (m *MapStore) Lookup(path string) (*Link, error) {
+	return m.lookup(path, anyLink)
+}
+
+// LookupActive is like Lookup but passes over links inactive at
+// now, see Link.Active.
+func (m *MapStore) LookupActive(path string, now time.Time) (*Link, error) {
+	return m.lookup(path, activeAt(now))
+}
+
+func (m *MapStore) lookup(path string, accept func(*Link) bool) (*Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return lookupPattern(path, accept, func(p string) (*Link, error) {
+		link, ok := m.links[p]
+		if !ok || link.URL == "" {
+			return nil, nil
+		}
+		return &link, nil
+	}, func(string) ([]Link, error) {
+		templates := []Link{}
+		for p, link := range m.links {
+			if strings.Contains(p, "{") {
+				templates = append(templates, link)
+			}
+		}
+		sortLinks(templates)
+		return templates, nil
+	})
+}
+
+// Put implements Store.
+func (m *MapStore) Put(link Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links[link.Path] = link
+	return nil
+}
+
+// Create adds the link unless its path is taken, see ErrExists.
+func (m *MapStore) Create(link Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.links[link.Path]; ok {
+		return ErrExists
+	}
+	m.links[link.Path] = link
+	return nil
+}
+
+// Delete implements Store.
+func (m *MapStore) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.links, path)
+	return nil
+}
+
+// List implements Store.
+func (m *MapStore) List() ([]Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	links := make([]Link, 0, len(m.links))
+	for _, link := range m.links {
+		links = append(links, link)
+	}
+	sortLinks(links)
+	return links, nil
+}
+
+func sortLinks(links []Link) {
+	sort.Slice(links, func(i, j int) bool { return links[i].Path < links[j].Path })
+}
@@ -0,0 +1,103 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMapStorePutDelete(t *testing.T) {
+	store := NewMapStore(map[string]string{"/a": "https://a.example.com"})
+	if err := store.Put(Link{Path: "/b", URL: "https://b.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].Path != "/a" || links[1].Path != "/b" {
+		t.Errorf("Store returned wrong links: got %v", links)
+	}
+
+	if err := store.Delete("/a"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := store.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != nil {
+		t.Errorf("Store returned deleted link: got %v", link)
+	}
+}
+
+func TestHandlerUsesStore(t *testing.T) {
+	store := NewMapStore(nil)
+	handler := Handler(store, getDefaultMux())
+
+	// links added after the handler is built must be served
+	store.Put(Link{Path: "/late", URL: "https://late.example.com"})
+
+	req := httptest.NewRequest("GET", "/late", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	res := rr.Result()
+	if status := res.StatusCode; status != http.StatusFound {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusFound)
+	}
+	expected := "https://late.example.com"
+	if expected != res.Header.Get("Location") {
+		t.Errorf("Handler returned wrong location: got %v want %v", res.Header.Get("Location"), expected)
+	}
+}
+
+func TestDuplicatePaths(t *testing.T) {
+	yaml := `
+- path: /a
+  url: https://one.example.com
+- path: /b
+  url: https://b.example.com
+- path: /a
+  url: https://two.example.com`
+
+	_, err := NewYAMLStore([]byte(yaml))
+	dup, ok := err.(*DuplicatePathsError)
+	if !ok {
+		t.Fatalf("Store returned wrong error: got %v", err)
+	}
+	if entries := dup.Paths["/a"]; len(entries) != 2 || entries[0] != 0 || entries[1] != 2 {
+		t.Errorf("Error reported wrong entries: got %v", dup.Paths)
+	}
+
+	warnings := []error{}
+	store, err := Lenient(NewYAMLStore, func(err error) { warnings = append(warnings, err) })([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Lenient reported wrong warnings: got %v", warnings)
+	}
+	if link, _ := store.Lookup("/a"); link == nil || link.URL != "https://two.example.com" {
+		t.Errorf("Store returned wrong link: got %v", link)
+	}
+
+	if _, err := Lenient(NewJSONStore, nil)([]byte("foo")); err == nil {
+		t.Errorf("Lenient did not return error")
+	}
+}
+
+func TestMultiStoreConflicts(t *testing.T) {
+	bolt := NewMapStore(map[string]string{"/a": "https://bolt.example.com", "/b": "https://b.example.com"})
+	json := NewMapStore(map[string]string{"/a": "https://json.example.com"})
+	yaml := NewMapStore(map[string]string{"/a": "https://yaml.example.com", "/c": "https://c.example.com"})
+	store := NewMultiStore(Source{"bolt", bolt}, Source{"json", json}, Source{"yaml", yaml})
+
+	conflicts, err := store.Conflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Path != "/a" || len(conflicts[0].Sources) != 3 || conflicts[0].Winner() != "bolt" {
+		t.Errorf("Store returned wrong conflicts: got %v", conflicts)
+	}
+}
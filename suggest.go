@@ -0,0 +1,212 @@
+package urlshort
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSuggestions is the number of paths suggested for a miss.
+const maxSuggestions = 5
+
+// maxSuggestLength bounds the paths suggestions are looked for, as
+// the edit distance takes time quadratic in the length.
+const maxSuggestLength = 256
+
+// suggestCacheTTL is how long NotFoundHandler reuses the candidate
+// paths before listing the store again.
+const suggestCacheTTL = 30 * time.Second
+
+// Suggest returns up to max paths of store close to path: within a
+// small edit distance of it, or prefixes of one another. The
+// closest come first. Template links and expired or inactive links
+// are never suggested.
+func Suggest(store Store, path string, max int) ([]string, error) {
+	candidates, err := suggestCandidates(store, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return closestPaths(candidates, path, max), nil
+}
+
+// suggestCandidates returns the paths of store which may be
+// suggested at now.
+func suggestCandidates(store Store, now time.Time) ([]string, error) {
+	links, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, link := range links {
+		if !link.IsTemplate() && !link.Expired(now) && link.Active(now) {
+			paths = append(paths, link.Path)
+		}
+	}
+	return paths, nil
+}
+
+// closestPaths returns up to max of paths close to path, closest
+// first.
+func closestPaths(paths []string, path string, max int) []string {
+	suggestions := []string{}
+	if len(path) > maxSuggestLength {
+		return suggestions
+	}
+	want := normalizePath(path)
+	maxDistance := len(want) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	type candidate struct {
+		path     string
+		distance int
+	}
+	candidates := []candidate{}
+	for _, p := range paths {
+		got := normalizePath(strings.TrimSuffix(p, "/*"))
+		if got == "" || got == want {
+			continue
+		}
+		d := editDistance(want, got)
+		if d <= maxDistance || strings.HasPrefix(got, want) || strings.HasPrefix(want, got+"/") {
+			candidates = append(candidates, candidate{p, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	if len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	for _, c := range candidates {
+		suggestions = append(suggestions, c.path)
+	}
+	return suggestions
+}
+
+// suggestCache holds the candidate paths of a store for
+// suggestCacheTTL, so misses do not list the store every time.
+type suggestCache struct {
+	store Store
+
+	mu      sync.Mutex
+	paths   []string
+	expires time.Time
+}
+
+func (c *suggestCache) candidates() ([]string, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Before(c.expires) {
+		return c.paths, nil
+	}
+	paths, err := suggestCandidates(c.store, now)
+	if err != nil {
+		return nil, err
+	}
+	c.paths, c.expires = paths, now.Add(suggestCacheTTL)
+	return paths, nil
+}
+
+func normalizePath(path string) string {
+	return strings.ToLower(strings.TrimSuffix(path, "/"))
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+type notFound struct {
+	Error       string   `json:"error"`
+	Path        string   `json:"path"`
+	Suggestions []string `json:"suggestions"`
+	CreateURL   string   `json:"create_url,omitempty"`
+}
+
+var notFoundPage = template.Must(template.New("not-found").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Unknown urlshort entry</title></head>
+<body>
+<h1>Unknown urlshort entry!</h1>
+<p>There is no link for <code>{{.Path}}</code>.</p>
+{{- if .Suggestions}}
+<p>Did you mean:</p>
+<ul>
+{{- range .Suggestions}}
+<li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .CreateURL}}
+<p><a href="{{.CreateURL}}">Create {{.Path}}</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// NotFoundHandler will return an http.Handler answering 404 Not
+// Found with the paths of store closest to the request path, see
+// Suggest. Only paths are suggested, never where they lead, as the
+// page is public. The candidate paths are listed at most every
+// suggestCacheTTL. Clients accepting JSON but not HTML get a JSON
+// object, browsers an HTML page. If createURL is set, it is linked
+// with the missing path appended as the "path" query parameter.
+func NotFoundHandler(store Store, createURL string) http.Handler {
+	cache := &suggestCache{store: store}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := notFound{
+			Error:       fmt.Sprintf("no link for path %q", r.URL.Path),
+			Path:        r.URL.Path,
+			Suggestions: []string{},
+		}
+		if paths, err := cache.candidates(); err == nil {
+			page.Suggestions = closestPaths(paths, r.URL.Path, maxSuggestions)
+		}
+		if createURL != "" {
+			page.CreateURL = createURL + "?path=" + url.QueryEscape(r.URL.Path)
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusNotFound, page)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		notFoundPage.Execute(w, page)
+	})
+}
+
+// wantsJSON reports whether the client accepts JSON but not HTML.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
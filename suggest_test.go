@@ -0,0 +1,112 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"/docs", "/docs", 0},
+		{"/docs", "/dosc", 2},
+		{"/docs", "/doc", 1},
+		{"kitten", "sitting", 3},
+		{"/café", "/cafe", 1},
+	} {
+		if got := editDistance(c.a, c.b); got != c.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	yaml := NewMapStore(map[string]string{
+		"/docs":          "https://docs.example.com",
+		"/docs-internal": "https://internal.example.com",
+		"/pr/{num}":      "https://github.com/pulls/{num}",
+		"/unrelated":     "https://unrelated.example.com",
+	})
+	bolt := NewMapStore(map[string]string{"/gh/*": "https://github.com/"})
+	store := NewMultiStore(Source{"bolt", bolt}, Source{"yaml", yaml})
+
+	suggestions, err := Suggest(store, "/Dcos", maxSuggestions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 1 || suggestions[0] != "/docs" {
+		t.Errorf("Expected /docs suggested, got %v", suggestions)
+	}
+	suggestions, _ = Suggest(store, "/doc", maxSuggestions)
+	if len(suggestions) != 2 || suggestions[0] != "/docs" || suggestions[1] != "/docs-internal" {
+		t.Errorf("Expected /docs then /docs-internal, got %v", suggestions)
+	}
+	suggestions, _ = Suggest(store, "/gh", maxSuggestions)
+	if len(suggestions) != 0 {
+		t.Errorf("Expected no suggestion for an exact wildcard prefix, got %v", suggestions)
+	}
+	suggestions, _ = Suggest(store, "/pr", maxSuggestions)
+	for _, path := range suggestions {
+		if path == "/pr/{num}" {
+			t.Errorf("Expected templates not suggested, got %v", suggestions)
+		}
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	store := NewMapStore(map[string]string{"/docs": "https://docs.example.com"})
+	handler := NotFoundHandler(store, "/ui/new")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/dosc", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*")
+	handler.ServeHTTP(rr, req)
+	body := rr.Body.String()
+	if rr.Code != http.StatusNotFound || !strings.Contains(body, `<a href="/docs">/docs</a>`) ||
+		!strings.Contains(body, `<a href="/ui/new?path=%2Fdosc">`) {
+		t.Errorf("Unexpected HTML response %d:\n%s", rr.Code, body)
+	}
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/dosc", nil)
+	req.Header.Set("Accept", "application/json")
+	handler.ServeHTTP(rr, req)
+	var page notFound
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusNotFound || len(page.Suggestions) != 1 || page.CreateURL != "/ui/new?path=%2Fdosc" {
+		t.Errorf("Unexpected JSON response %d: %+v", rr.Code, page)
+	}
+	if strings.Contains(body+rr.Body.String(), "docs.example.com") {
+		t.Errorf("Expected the targets of suggestions not disclosed")
+	}
+}
+
+// listCounter counts the listings of a Store.
+type listCounter struct {
+	Store
+	lists int
+}
+
+func (c *listCounter) List() ([]Link, error) {
+	c.lists++
+	return c.Store.List()
+}
+
+func TestNotFoundHandlerCache(t *testing.T) {
+	store := &listCounter{Store: NewMapStore(map[string]string{"/docs": "https://docs.example.com"})}
+	handler := NotFoundHandler(store, "")
+	for _, path := range []string{"/dosc", "/doc", "/" + strings.Repeat("x", maxSuggestLength)} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if store.lists != 1 {
+		t.Errorf("Expected the candidates listed once, got %d", store.lists)
+	}
+}
@@ -0,0 +1,364 @@
+package urlshort
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UIPrefix is the path under which UIHandler expects to be
+// mounted.
+const UIPrefix = "/ui"
+
+// csrfCookie holds the token forms must echo back, so that other
+// sites can not post forms on behalf of a user.
+const csrfCookie = "urlshort_csrf"
+
+//go:embed ui
+var uiFiles embed.FS
+
+var uiTemplates = template.Must(template.ParseFS(uiFiles, "ui/*.html"))
+
+type uiHandler struct {
+	links    *MultiStore
+	writable string
+	store    Store
+	codes    *CodeGenerator
+}
+
+type uiPage struct {
+	Prefix   string
+	Title    string
+	Error    string
+	CSRF     string
+	Writable bool
+	// list
+	Query string
+	Links []Link
+	// form
+	Link      Link
+	Editing   bool
+	Shareable bool
+	Statuses  []int
+	Policies  []QueryPolicy
+
+	writable  string
+	principal *Principal
+}
+
+// Editable reports whether link can be changed through the UI by
+// the page's principal.
+func (p *uiPage) Editable(link Link) bool {
+	return p.Writable && link.Source == p.writable && p.principal.CanEdit(&link)
+}
+
+// ConfigManaged reports whether link comes from a config file.
+func (p *uiPage) ConfigManaged(link Link) bool {
+	return link.Source != p.writable
+}
+
+// UIHandler will return an http.Handler serving an HTML interface
+// listing and searching the links of every source of links. Links
+// of the source named writable, if any, can be created, edited and
+// deleted by the principals allowed to, see Principal.CanEdit; if
+// codes is not nil, links created without a path are given one
+// minted by the generator. It must be mounted at
+// UIPrefix, and serves:
+//
+//     GET  /ui                 list links, searching for ?q=
+//     GET  /ui/new?path=<path> form to create a link
+//     POST /ui/new             create a link
+//     GET  /ui/edit?path=<path> form to edit a link
+//     POST /ui/edit?path=<path> update a link
+//     POST /ui/delete          delete the link of the form's path
+//
+// Forms are protected against cross-site request forgery by a
+// token, set in a cookie, which they must post back.
+func UIHandler(links *MultiStore, writable string, codes *CodeGenerator) http.Handler {
+	u := &uiHandler{links: links, writable: writable, codes: codes}
+	for _, s := range links.Sources() {
+		if s.Name == writable {
+			u.store = s.Store
+		}
+	}
+	return u
+}
+
+func (u *uiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page := &uiPage{
+		Prefix:    UIPrefix,
+		CSRF:      csrfToken(w, r),
+		Writable:  u.store != nil,
+		writable:  u.writable,
+		principal: PrincipalFrom(r),
+	}
+	path := strings.TrimPrefix(r.URL.Path, UIPrefix)
+	switch path {
+	case "/style.css":
+		css, _ := uiFiles.ReadFile("ui/style.css")
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Write(css)
+		return
+	case "", "/":
+	default:
+		if u.store == nil {
+			http.Error(w, "links are read-only", http.StatusForbidden)
+			return
+		}
+	}
+	if r.Method == http.MethodPost {
+		if !validCSRF(r) {
+			http.Error(w, "invalid or missing CSRF token, reload the form and try again", http.StatusForbidden)
+			return
+		}
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case path == "" || path == "/":
+		u.list(w, r, page)
+	case path == "/new" && r.Method == http.MethodPost:
+		u.create(w, r, page)
+	case path == "/new":
+		page.Link.Path = r.URL.Query().Get("path")
+		page.Shareable = true
+		u.form(w, http.StatusOK, page, "New link")
+	case path == "/edit":
+		u.edit(w, r, page)
+	case path == "/delete" && r.Method == http.MethodPost:
+		u.delete(w, r, page)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (u *uiHandler) list(w http.ResponseWriter, r *http.Request, page *uiPage) {
+	page.Query = strings.TrimSpace(r.URL.Query().Get("q"))
+	q := strings.ToLower(page.Query)
+	page.Links = []Link{}
+	for _, s := range u.links.Sources() {
+		links, err := s.Store.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, link := range links {
+			if q != "" && !strings.Contains(strings.ToLower(link.Path), q) && !strings.Contains(strings.ToLower(link.URL), q) {
+				continue
+			}
+			link.Source = s.Name
+			page.Links = append(page.Links, link)
+		}
+	}
+	// stable, so a path defined in several sources is listed
+	// in order of precedence
+	sort.SliceStable(page.Links, func(i, j int) bool { return page.Links[i].Path < page.Links[j].Path })
+	u.render(w, http.StatusOK, "list", page)
+}
+
+func (u *uiHandler) form(w http.ResponseWriter, status int, page *uiPage, title string) {
+	page.Title = title
+	page.Statuses = []int{http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect}
+	page.Policies = []QueryPolicy{QueryDrop, QueryAppend, QueryMerge, QueryTargetWins}
+	u.render(w, status, "form", page)
+}
+
+func (u *uiHandler) render(w http.ResponseWriter, status int, name string, page *uiPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	uiTemplates.ExecuteTemplate(w, name, page)
+}
+
+// formLink reads the link fields of the posted form into link.
+func formLink(r *http.Request, link *Link) error {
+	if _, ok := r.PostForm["editors"]; ok {
+		link.Editors = nil
+		for _, group := range strings.Split(r.PostFormValue("editors"), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				link.Editors = append(link.Editors, group)
+			}
+		}
+	}
+	link.URL = strings.TrimSpace(r.PostFormValue("url"))
+	link.Query = QueryPolicy(r.PostFormValue("query"))
+	link.Status = 0
+	if s := r.PostFormValue("status"); s != "" {
+		status, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid redirect status %q", s)
+		}
+		link.Status = status
+	}
+	return nil
+}
+
+func (u *uiHandler) create(w http.ResponseWriter, r *http.Request, page *uiPage) {
+	page.Shareable = true
+	page.Link.Path = strings.TrimSpace(r.PostFormValue("path"))
+	if err := formLink(r, &page.Link); err != nil {
+		page.Error = err.Error()
+		u.form(w, http.StatusBadRequest, page, "New link")
+		return
+	}
+
+	var codes *CodeGenerator
+	if page.Link.Path == "" && u.codes != nil {
+		path, err := u.codes.Generate()
+		if err != nil {
+			page.Error = err.Error()
+			u.form(w, http.StatusServiceUnavailable, page, "New link")
+			return
+		}
+		page.Link.Path, codes = path, u.codes
+	}
+	if err := validateLink(page.Link); err != nil {
+		page.Error = err.Error()
+		u.form(w, http.StatusBadRequest, page, "New link")
+		return
+	}
+	// the links of every source, so config-managed ones are
+	// not shadowed
+	existing, err := lookupExact(u.links, page.Link.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		page.Error = fmt.Sprintf("path %q already exists", page.Link.Path)
+		if page.ConfigManaged(*existing) {
+			page.Error = fmt.Sprintf("path %q already exists as a config-managed link", page.Link.Path)
+		}
+		u.form(w, http.StatusConflict, page, "New link")
+		return
+	}
+	applyOwnership(page.principal, &page.Link, nil)
+	err = createGenerated(storeFor(u.store, r), &page.Link, codes)
+	if err == ErrExists {
+		page.Error = fmt.Sprintf("path %q already exists", page.Link.Path)
+		u.form(w, http.StatusConflict, page, "New link")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.redirectToList(w, r, page.Link.Path)
+}
+
+func (u *uiHandler) edit(w http.ResponseWriter, r *http.Request, page *uiPage) {
+	path := r.URL.Query().Get("path")
+	existing, err := lookupExact(u.store, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing == nil {
+		http.Error(w, fmt.Sprintf("no link for path %q", path), http.StatusNotFound)
+		return
+	}
+	if !page.principal.CanEdit(existing) {
+		http.Error(w, fmt.Sprintf("not allowed to change link %q", path), http.StatusForbidden)
+		return
+	}
+	// settings the form does not show, such as schedules, are
+	// kept as they are
+	page.Link = *existing
+	page.Editing = true
+	page.Shareable = page.principal.CanShare(existing)
+	if r.Method != http.MethodPost {
+		u.form(w, http.StatusOK, page, "Edit "+path)
+		return
+	}
+	err = formLink(r, &page.Link)
+	if err == nil {
+		err = validateLink(page.Link)
+	}
+	if err != nil {
+		page.Error = err.Error()
+		u.form(w, http.StatusBadRequest, page, "Edit "+path)
+		return
+	}
+	applyOwnership(page.principal, &page.Link, existing)
+	if err := storeFor(u.store, r).Put(page.Link); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.redirectToList(w, r, path)
+}
+
+func (u *uiHandler) delete(w http.ResponseWriter, r *http.Request, page *uiPage) {
+	path := r.PostFormValue("path")
+	existing, err := lookupExact(u.store, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && !page.principal.CanEdit(existing) {
+		http.Error(w, fmt.Sprintf("not allowed to delete link %q", path), http.StatusForbidden)
+		return
+	}
+	if err := storeFor(u.store, r).Delete(path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.redirectToList(w, r, "")
+}
+
+func (u *uiHandler) redirectToList(w http.ResponseWriter, r *http.Request, query string) {
+	target := UIPrefix
+	if query != "" {
+		target += "?q=" + url.QueryEscape(query)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// csrfToken returns the CSRF token of the client, setting a new
+// one if it has none.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 64 {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     UIPrefix,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// validCSRF reports whether the posted form carries the token of
+// the client's cookie and, if the browser sent an Origin, comes
+// from this host.
+func validCSRF(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	token := r.PostFormValue("csrf_token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.Value)) == 1
+}
@@ -0,0 +1,120 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// doUIRequest sends a request to the UI with the CSRF cookie set
+// to token, if any, posting form values if not nil.
+func doUIRequest(handler http.Handler, method, target, token string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUI(t *testing.T) {
+	bolt := NewMapStore(map[string]string{"/docs": "https://docs.example.com"})
+	yaml := NewMapStore(map[string]string{"/docs": "https://old-docs.example.com", "/gh/*": "https://github.com/"})
+	handler := UIHandler(NewMultiStore(Source{"bolt", bolt}, Source{"yaml", yaml}), "bolt", nil)
+
+	rr := doUIRequest(handler, "GET", UIPrefix+"?q=DOCS", "", nil)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || strings.Count(body, `<a href="/docs">/docs</a>`) != 2 || strings.Contains(body, "/gh/*") {
+		t.Errorf("Expected /docs of both sources listed, got %d:\n%s", rr.Code, body)
+	}
+	if strings.Count(body, "config-managed") != 1 || !strings.Contains(body, `href="/ui/edit?path=%2fdocs"`) {
+		t.Errorf("Expected only the bolt link to be editable:\n%s", body)
+	}
+	var token string
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == csrfCookie {
+			token = c.Value
+		}
+	}
+	if token == "" || !strings.Contains(body, token) {
+		t.Fatalf("Expected a CSRF token in the cookie and the forms")
+	}
+
+	form := url.Values{"csrf_token": {token}, "path": {"/new"}, "url": {"https://new.example.com"}, "status": {"301"}}
+	rr = doUIRequest(handler, "POST", UIPrefix+"/new", token, form)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected 303 after creating a link, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if link, _ := bolt.Lookup("/new"); link == nil || link.URL != "https://new.example.com" || link.Status != 301 {
+		t.Errorf("Expected the link created, got %v", link)
+	}
+
+	form = url.Values{"csrf_token": {token}, "url": {"https://newer.example.com"}}
+	rr = doUIRequest(handler, "POST", UIPrefix+"/edit?path=/new", token, form)
+	if link, _ := bolt.Lookup("/new"); rr.Code != http.StatusSeeOther || link.URL != "https://newer.example.com" || link.Status != 0 {
+		t.Errorf("Expected the link updated, got %d %v", rr.Code, link)
+	}
+
+	form = url.Values{"csrf_token": {token}, "path": {"/new"}, "url": {"https://other.example.com"}}
+	rr = doUIRequest(handler, "POST", UIPrefix+"/new", token, form)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing path, got %d", rr.Code)
+	}
+
+	form = url.Values{"csrf_token": {token}, "path": {"/new"}}
+	rr = doUIRequest(handler, "POST", UIPrefix+"/delete", token, form)
+	if link, _ := bolt.Lookup("/new"); rr.Code != http.StatusSeeOther || link != nil {
+		t.Errorf("Expected the link deleted, got %d %v", rr.Code, link)
+	}
+}
+
+func TestUICSRF(t *testing.T) {
+	bolt := NewMapStore(nil)
+	handler := UIHandler(NewMultiStore(Source{"bolt", bolt}), "bolt", nil)
+	token := strings.Repeat("ab", 32)
+	form := url.Values{"path": {"/a"}, "url": {"https://a.example.com"}}
+
+	for name, c := range map[string]struct {
+		cookie, field string
+	}{
+		"no cookie":       {"", token},
+		"no field":        {token, ""},
+		"mismatched":      {token, strings.Repeat("cd", 32)},
+		"matching cookie": {token, token},
+	} {
+		form.Set("csrf_token", c.field)
+		rr := doUIRequest(handler, "POST", UIPrefix+"/new", c.cookie, form)
+		if expected := c.cookie != "" && c.cookie == c.field; expected != (rr.Code == http.StatusSeeOther) {
+			t.Errorf("%s: unexpected status %d", name, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequest("POST", UIPrefix+"/delete", strings.NewReader("path=/a&csrf_token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a cross-origin post, got %d", rr.Code)
+	}
+}
+
+func TestUIReadOnly(t *testing.T) {
+	handler := UIHandler(NewMultiStore(Source{"yaml", NewMapStore(nil)}), "", nil)
+	if rr := doUIRequest(handler, "GET", UIPrefix+"/new", "", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for forms without a writable source, got %d", rr.Code)
+	}
+	if rr := doUIRequest(handler, "GET", UIPrefix+"/style.css", "", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected the stylesheet served, got %d", rr.Code)
+	}
+}